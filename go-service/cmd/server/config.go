@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config is the service configuration, read from the environment.
+type config struct {
+	KeyDBAddr      string
+	KeyDBUser      string
+	KeyDBPass      string
+	KeyDBDB        int
+	KeyDBTLS       bool
+	KeyDBTLSCA     string
+	KeyDBTLSCert   string
+	KeyDBTLSKey    string
+	KeyDBOpTimeout time.Duration
+	StartupTimeout time.Duration
+	ShutdownGrace  time.Duration
+
+	WorkerCount    int
+	SimBinary      string
+	MaxCycles      uint64
+	Timeout        time.Duration
+	MinTimeout     time.Duration
+	MaxTimeout     time.Duration
+	SimVersion     string
+	VersionFile    string
+	ValidateImages bool
+	AllowedSHA256  map[string]bool // nil allows all binaries
+	MaxBinaryBytes int
+	MaxOutputBytes int
+
+	OTELEndpoint  string
+	LogSampleRate float64
+	SlowJob       time.Duration
+
+	MaxPending     int
+	StaleAfter     time.Duration
+	MaxAttempts    int
+	ReaperInterval time.Duration
+	DepthInterval  time.Duration
+
+	BlobBackend string
+	BlobDir     string
+
+	// parseErrs collects environment values that could not be parsed, so
+	// validate reports them instead of silently using the default.
+	parseErrs []error
+}
+
+// loadConfig reads the configuration from the environment. Call validate
+// before using it.
+func loadConfig() config {
+	var c config
+	c.KeyDBAddr = c.str("KEYDB_ADDR", "localhost:6379")
+	c.KeyDBUser = c.str("KEYDB_USERNAME", "")
+	c.KeyDBPass = c.str("KEYDB_PASSWORD", "")
+	c.KeyDBDB = c.int("KEYDB_DB", 0)
+	c.KeyDBTLS = c.bool("KEYDB_TLS", false)
+	c.KeyDBTLSCA = c.str("KEYDB_TLS_CA", "")
+	c.KeyDBTLSCert = c.str("KEYDB_TLS_CERT", "")
+	c.KeyDBTLSKey = c.str("KEYDB_TLS_KEY", "")
+	c.KeyDBOpTimeout = time.Duration(c.int("KEYDB_OP_TIMEOUT_MS", 5000)) * time.Millisecond
+	c.StartupTimeout = time.Duration(c.int("KEYDB_STARTUP_TIMEOUT_SEC", 30)) * time.Second
+	c.ShutdownGrace = time.Duration(c.int("SHUTDOWN_GRACE_SEC", 8)) * time.Second // below Docker's default 10s stop timeout
+
+	c.WorkerCount = c.int("WORKER_COUNT", 4)
+	c.SimBinary = c.str("SIM_BINARY", "./stm32sim")
+	c.MaxCycles = c.uint64("SIM_MAX_CYCLES", 10_000_000)
+	c.Timeout = time.Duration(c.int("SIM_TIMEOUT_SEC", 30)) * time.Second
+	c.MinTimeout = time.Duration(c.int("SIM_TIMEOUT_MIN_SEC", 1)) * time.Second
+	c.MaxTimeout = time.Duration(c.int("SIM_TIMEOUT_MAX_SEC", 300)) * time.Second
+	c.SimVersion = c.str("SIM_VERSION", "")
+	c.VersionFile = c.str("SIM_VERSION_FILE", "./version.txt")
+	c.ValidateImages = c.bool("SIM_VALIDATE_IMAGE", true)
+	if list := c.str("SIM_ALLOWED_SHA256", ""); list != "" { // comma-separated
+		c.AllowedSHA256 = map[string]bool{}
+		for _, h := range strings.Split(list, ",") {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+				c.AllowedSHA256[h] = true
+			}
+		}
+	}
+	c.MaxBinaryBytes = c.int("SIM_MAX_BINARY_BYTES", 64*1024) // STM32F103C8 flash size; 0 disables the cap
+	c.MaxOutputBytes = c.int("SIM_MAX_OUTPUT_BYTES", 1<<20)   // 0 disables the cap
+
+	c.OTELEndpoint = c.str("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	c.LogSampleRate = c.float("LOG_SAMPLE_RATE", 1.0)
+	c.SlowJob = time.Duration(c.int("LOG_SLOW_JOB_MS", 5000)) * time.Millisecond
+
+	c.MaxPending = c.int("SIM_MAX_PENDING", 0) // 0 disables the cap
+	// StaleAfter must exceed SIM_TIMEOUT_MAX_SEC; see validate.
+	c.StaleAfter = time.Duration(c.int("JOB_STALE_AFTER_SEC", 360)) * time.Second
+	c.MaxAttempts = c.int("JOB_MAX_ATTEMPTS", 3)
+	c.ReaperInterval = time.Duration(c.int("REAPER_INTERVAL_SEC", 30)) * time.Second
+	c.DepthInterval = time.Duration(c.int("QUEUE_DEPTH_INTERVAL_SEC", 10)) * time.Second
+
+	c.BlobBackend = c.str("BLOB_BACKEND", "none")
+	c.BlobDir = c.str("BLOB_DIR", "/var/lib/stm32sim/blobs")
+	return c
+}
+
+// validate reports every configuration problem at once.
+func (c config) validate() error {
+	errs := append([]error(nil), c.parseErrs...)
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if _, port, err := net.SplitHostPort(c.KeyDBAddr); err != nil {
+		errs = append(errs, fmt.Errorf("KEYDB_ADDR %q: %v", c.KeyDBAddr, err))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Errorf("KEYDB_ADDR %q: port must be 1-65535", c.KeyDBAddr))
+	}
+	check(c.KeyDBDB >= 0, "KEYDB_DB must not be negative")
+	check((c.KeyDBTLSCert == "") == (c.KeyDBTLSKey == ""), "KEYDB_TLS_CERT and KEYDB_TLS_KEY must be set together")
+	check(c.KeyDBTLS || (c.KeyDBTLSCA == "" && c.KeyDBTLSCert == ""), "KEYDB_TLS_* files are set but KEYDB_TLS is not true")
+	check(c.KeyDBOpTimeout >= 0, "KEYDB_OP_TIMEOUT_MS must not be negative")
+	check(c.StartupTimeout >= 0, "KEYDB_STARTUP_TIMEOUT_SEC must not be negative")
+	check(c.ShutdownGrace >= 0, "SHUTDOWN_GRACE_SEC must not be negative")
+
+	check(c.WorkerCount > 0, "WORKER_COUNT must be positive")
+	// Resolve the binary as exec does, so a bare name is looked up on PATH.
+	if _, err := exec.LookPath(c.SimBinary); err != nil {
+		errs = append(errs, fmt.Errorf("SIM_BINARY: %v", err))
+	}
+	check(c.MinTimeout > 0, "SIM_TIMEOUT_MIN_SEC must be positive")
+	check(c.MinTimeout <= c.Timeout && c.Timeout <= c.MaxTimeout,
+		"SIM_TIMEOUT_SEC (%v) must lie within SIM_TIMEOUT_MIN_SEC (%v) and SIM_TIMEOUT_MAX_SEC (%v)",
+		c.Timeout, c.MinTimeout, c.MaxTimeout)
+	check(c.MaxBinaryBytes >= 0, "SIM_MAX_BINARY_BYTES must not be negative")
+	check(c.MaxOutputBytes >= 0, "SIM_MAX_OUTPUT_BYTES must not be negative")
+	for h := range c.AllowedSHA256 {
+		b, err := hex.DecodeString(h)
+		check(err == nil && len(b) == 32, "SIM_ALLOWED_SHA256: %q is not a sha256 hex digest", h)
+	}
+
+	check(c.LogSampleRate >= 0 && c.LogSampleRate <= 1, "LOG_SAMPLE_RATE must be between 0 and 1")
+	check(c.SlowJob >= 0, "LOG_SLOW_JOB_MS must not be negative")
+
+	check(c.StaleAfter > c.MaxTimeout,
+		"JOB_STALE_AFTER_SEC (%v) must exceed SIM_TIMEOUT_MAX_SEC (%v) or running jobs get requeued",
+		c.StaleAfter, c.MaxTimeout)
+	check(c.MaxPending >= 0, "SIM_MAX_PENDING must not be negative")
+	check(c.MaxAttempts > 0, "JOB_MAX_ATTEMPTS must be positive")
+	check(c.ReaperInterval > 0, "REAPER_INTERVAL_SEC must be positive")
+	check(c.DepthInterval > 0, "QUEUE_DEPTH_INTERVAL_SEC must be positive")
+
+	switch c.BlobBackend {
+	case "none":
+	case "fs":
+		check(c.BlobDir != "", "BLOB_DIR must be set when BLOB_BACKEND=fs")
+	default:
+		errs = append(errs, fmt.Errorf("BLOB_BACKEND %q: must be none or fs", c.BlobBackend))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *config) str(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func (c *config) int(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			c.parseErrs = append(c.parseErrs, fmt.Errorf("%s %q: not an integer", key, v))
+			return fallback
+		}
+		return n
+	}
+	return fallback
+}
+
+func (c *config) uint64(key string, fallback uint64) uint64 {
+	if v := os.Getenv(key); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.parseErrs = append(c.parseErrs, fmt.Errorf("%s %q: not an unsigned integer", key, v))
+			return fallback
+		}
+		return n
+	}
+	return fallback
+}
+
+func (c *config) float(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			c.parseErrs = append(c.parseErrs, fmt.Errorf("%s %q: not a number", key, v))
+			return fallback
+		}
+		return f
+	}
+	return fallback
+}
+
+func (c *config) bool(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.parseErrs = append(c.parseErrs, fmt.Errorf("%s %q: not a boolean", key, v))
+			return fallback
+		}
+		return b
+	}
+	return fallback
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns the default configuration with SIM_BINARY pointing at
+// a file that exists.
+func validConfig(t *testing.T) config {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "stm32sim")
+	if err := os.WriteFile(bin, nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c := loadConfig()
+	c.SimBinary = bin
+	return c
+}
+
+func TestValidateDefaults(t *testing.T) {
+	if err := validConfig(t).validate(); err != nil {
+		t.Fatalf("default configuration is invalid: %v", err)
+	}
+}
+
+func TestValidateFindsSimulatorOnPath(t *testing.T) {
+	c := validConfig(t)
+	t.Setenv("PATH", filepath.Dir(c.SimBinary))
+	c.SimBinary = filepath.Base(c.SimBinary)
+	if err := c.validate(); err != nil {
+		t.Errorf("SIM_BINARY=%s on PATH rejected: %v", c.SimBinary, err)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mutate func(*config)
+		want   string
+	}{
+		{"empty KeyDB address", func(c *config) { c.KeyDBAddr = "" }, "KEYDB_ADDR"},
+		{"KeyDB port zero", func(c *config) { c.KeyDBAddr = "keydb:0" }, "port must be 1-65535"},
+		{"KeyDB port too large", func(c *config) { c.KeyDBAddr = "keydb:70000" }, "port must be 1-65535"},
+		{"negative DB", func(c *config) { c.KeyDBDB = -1 }, "KEYDB_DB"},
+		{"cert without key", func(c *config) { c.KeyDBTLS = true; c.KeyDBTLSCert = "c.pem" }, "set together"},
+		{"TLS files without TLS", func(c *config) { c.KeyDBTLSCA = "ca.pem" }, "KEYDB_TLS is not true"},
+		{"no workers", func(c *config) { c.WorkerCount = 0 }, "WORKER_COUNT"},
+		{"missing simulator", func(c *config) { c.SimBinary = "/nonexistent/stm32sim" }, "SIM_BINARY"},
+		{"simulator not on PATH", func(c *config) { c.SimBinary = "no-such-stm32sim" }, "SIM_BINARY"},
+		{"zero min timeout", func(c *config) { c.MinTimeout = 0 }, "SIM_TIMEOUT_MIN_SEC"},
+		{"timeout above max", func(c *config) { c.Timeout = c.MaxTimeout + time.Second }, "must lie within"},
+		{"negative binary cap", func(c *config) { c.MaxBinaryBytes = -1 }, "SIM_MAX_BINARY_BYTES"},
+		{"negative output cap", func(c *config) { c.MaxOutputBytes = -1 }, "SIM_MAX_OUTPUT_BYTES"},
+		{"bad allow-list hash", func(c *config) { c.AllowedSHA256 = map[string]bool{"abc": true} }, "not a sha256"},
+		{"sample rate above 1", func(c *config) { c.LogSampleRate = 1.5 }, "LOG_SAMPLE_RATE"},
+		{"negative pending cap", func(c *config) { c.MaxPending = -1 }, "SIM_MAX_PENDING"},
+		{"stale before max timeout", func(c *config) { c.StaleAfter = c.MaxTimeout }, "JOB_STALE_AFTER_SEC"},
+		{"no attempts", func(c *config) { c.MaxAttempts = 0 }, "JOB_MAX_ATTEMPTS"},
+		{"zero reaper interval", func(c *config) { c.ReaperInterval = 0 }, "REAPER_INTERVAL_SEC"},
+		{"zero depth interval", func(c *config) { c.DepthInterval = 0 }, "QUEUE_DEPTH_INTERVAL_SEC"},
+		{"unknown blob backend", func(c *config) { c.BlobBackend = "s3" }, "BLOB_BACKEND"},
+		{"fs backend without dir", func(c *config) { c.BlobBackend = "fs"; c.BlobDir = "" }, "BLOB_DIR"},
+	} {
+		c := validConfig(t)
+		tc.mutate(&c)
+		err := c.validate()
+		if err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		} else if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error %q does not mention %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	c := validConfig(t)
+	c.WorkerCount = 0
+	c.LogSampleRate = -1
+	c.BlobBackend = "s3"
+	err := c.validate()
+	if err == nil {
+		t.Fatal("accepted")
+	}
+	if n := len(strings.Split(err.Error(), "\n")); n != 3 {
+		t.Errorf("reported %d problems, want 3: %v", n, err)
+	}
+}
+
+func TestValidateReportsUnparseableValues(t *testing.T) {
+	t.Setenv("WORKER_COUNT", "four")
+	t.Setenv("KEYDB_TLS", "maybe")
+	c := validConfig(t)
+	err := c.validate()
+	if err == nil {
+		t.Fatal("accepted unparseable values")
+	}
+	for _, want := range []string{`WORKER_COUNT "four": not an integer`, `KEYDB_TLS "maybe": not a boolean`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -5,65 +5,73 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"stm32sim-service/internal/blob"
 	"stm32sim-service/internal/queue"
 	"stm32sim-service/internal/simulator"
 	"stm32sim-service/internal/telemetry"
 	"stm32sim-service/internal/worker"
 )
 
-func getenv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return fallback
-}
+// Bounds for the delay between startup pings; variables so tests can
+// shorten them.
+var (
+	keydbRetryMin = 500 * time.Millisecond
+	keydbRetryMax = 5 * time.Second
+)
 
-func getenvInt(key string, fallback int) int {
-	if v := os.Getenv(key); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			return n
-		}
-	}
-	return fallback
+// pinger is the part of *queue.Queue that waitForKeyDB uses.
+type pinger interface {
+	Ping(ctx context.Context) error
 }
 
-func getenvUint64(key string, fallback uint64) uint64 {
-	if v := os.Getenv(key); v != "" {
-		if n, err := strconv.ParseUint(v, 10, 64); err == nil {
-			return n
+// waitForKeyDB pings KeyDB with backoff until it answers or timeout elapses,
+// so workers never start pulling jobs before the queue is reachable.
+// A zero timeout makes a single attempt.
+func waitForKeyDB(ctx context.Context, q pinger, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := keydbRetryMin
+	for {
+		err := q.Ping(ctx)
+		if err == nil || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		slog.Warn("KeyDB not ready, retrying", "err", err, "retry_in", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
 		}
+		delay = min(delay*2, keydbRetryMax)
 	}
-	return fallback
 }
 
 func main() {
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
 
-	keydbAddr   := getenv("KEYDB_ADDR", "localhost:6379")
-	workerCount := getenvInt("WORKER_COUNT", 4)
-	simBinary   := getenv("SIM_BINARY", "./stm32sim")
-	maxCycles   := getenvUint64("SIM_MAX_CYCLES", 10_000_000)
-	timeoutSec  := getenvInt("SIM_TIMEOUT_SEC", 30)
-	otelEndpoint := getenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	cfg := loadConfig()
+	if err := cfg.validate(); err != nil {
+		slog.Error("invalid configuration", "err", err)
+		os.Exit(1)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
 	// --- Telemetry ---
 	var tel *telemetry.Provider
-	if otelEndpoint != "" {
+	if cfg.OTELEndpoint != "" {
 		var err error
-		tel, err = telemetry.Init(ctx, otelEndpoint)
+		tel, err = telemetry.Init(ctx, cfg.OTELEndpoint)
 		if err != nil {
 			slog.Error("failed to init telemetry", "err", err)
 			os.Exit(1)
 		}
 		defer tel.Shutdown(context.Background())
-		slog.Info("OpenTelemetry enabled", "endpoint", otelEndpoint)
+		slog.Info("OpenTelemetry enabled", "endpoint", cfg.OTELEndpoint)
 	} else {
 		slog.Warn("OTEL_EXPORTER_OTLP_ENDPOINT not set — telemetry disabled (using no-op provider)")
 		tel = &telemetry.Provider{}
@@ -74,28 +82,113 @@ func main() {
 	}
 
 	// --- KeyDB ---
-	q := queue.New(keydbAddr)
-	if err := q.Ping(ctx); err != nil {
-		slog.Error("cannot connect to KeyDB", "addr", keydbAddr, "err", err)
+	q, err := queue.New(queue.Options{
+		Addr:        cfg.KeyDBAddr,
+		Username:    cfg.KeyDBUser,
+		Password:    cfg.KeyDBPass,
+		DB:          cfg.KeyDBDB,
+		TLS:         cfg.KeyDBTLS,
+		TLSCAFile:   cfg.KeyDBTLSCA,
+		TLSCertFile: cfg.KeyDBTLSCert,
+		TLSKeyFile:  cfg.KeyDBTLSKey,
+		OpTimeout:   cfg.KeyDBOpTimeout,
+	})
+	if err != nil {
+		slog.Error("invalid KeyDB config", "err", err)
+		os.Exit(1)
+	}
+	if err := waitForKeyDB(ctx, q, cfg.StartupTimeout); err != nil {
+		slog.Error("cannot connect to KeyDB", "addr", cfg.KeyDBAddr, "err", err)
 		os.Exit(1)
 	}
-	slog.Info("KeyDB connected", "addr", keydbAddr)
+	slog.Info("KeyDB connected", "addr", cfg.KeyDBAddr)
+	if err := q.SetMaxPending(ctx, cfg.MaxPending); err != nil {
+		slog.Error("failed to publish SIM_MAX_PENDING", "err", err)
+		os.Exit(1)
+	}
+	if cfg.MaxPending > 0 {
+		slog.Info("pending queue capped", "max_pending", cfg.MaxPending)
+	}
 
 	// --- Simulator config ---
+	simVersion := cfg.SimVersion
+	if simVersion == "" {
+		if b, err := os.ReadFile(cfg.VersionFile); err == nil {
+			simVersion = strings.TrimSpace(string(b))
+		} else {
+			slog.Warn("emulator version unknown", "file", cfg.VersionFile, "err", err)
+			simVersion = "unknown"
+		}
+	}
 	simCfg := simulator.Config{
-		BinaryPath: simBinary,
-		MaxCycles:  maxCycles,
-		Timeout:    time.Duration(timeoutSec) * time.Second,
+		BinaryPath:     cfg.SimBinary,
+		MaxCycles:      cfg.MaxCycles,
+		Timeout:        cfg.Timeout,
+		Version:        simVersion,
+		MaxOutputBytes: cfg.MaxOutputBytes,
+	}
+	slog.Info("emulator version", "version", simVersion)
+
+	// --- Worker config ---
+	workerCfg := worker.Config{
+		LogSampleRate:    cfg.LogSampleRate,
+		SlowJobThreshold: cfg.SlowJob,
+		MaxBinaryBytes:   cfg.MaxBinaryBytes,
+		ValidateImages:   cfg.ValidateImages,
+		AllowedSHA256:    cfg.AllowedSHA256,
+		MinTimeout:       cfg.MinTimeout,
+		MaxTimeout:       cfg.MaxTimeout,
+	}
+	if len(cfg.AllowedSHA256) > 0 {
+		slog.Info("binary allow-list enabled", "entries", len(cfg.AllowedSHA256))
+	}
+
+	// --- Blob store ---
+	if cfg.BlobBackend == "fs" {
+		store, err := blob.NewFS(cfg.BlobDir)
+		if err != nil {
+			slog.Error("failed to init blob store", "dir", cfg.BlobDir, "err", err)
+			os.Exit(1)
+		}
+		workerCfg.Blobs = store
+		slog.Info("blob store enabled", "backend", cfg.BlobBackend, "dir", cfg.BlobDir)
 	}
 
 	// --- Worker pool ---
-	pool := worker.NewPool(workerCount, q, simCfg, tel)
+	pool := worker.NewPool(cfg.WorkerCount, q, workerCfg, simCfg, tel)
 	pool.Start(ctx)
-	slog.Info("worker pool started", "workers", workerCount)
+	slog.Info("worker pool started", "workers", cfg.WorkerCount, "instance", pool.Instance())
+
+	// --- Stale job reaper ---
+	reaper := worker.NewReaper(q, worker.ReaperConfig{
+		Interval:        cfg.ReaperInterval,
+		StaleAfter:      cfg.StaleAfter,
+		MaxAttempts:     cfg.MaxAttempts,
+		EmulatorVersion: simVersion,
+	})
+	reaperDone := make(chan struct{})
+	go func() {
+		defer close(reaperDone)
+		reaper.Run(ctx)
+	}()
+
+	// --- Queue depth sampler ---
+	sampler := worker.NewDepthSampler(q, tel, cfg.DepthInterval)
+	samplerDone := make(chan struct{})
+	go func() {
+		defer close(samplerDone)
+		sampler.Run(ctx)
+	}()
 
 	// Block until signal
 	<-ctx.Done()
 	slog.Info("shutdown signal received, draining workers...")
-	pool.Shutdown()
+	pool.Shutdown(cfg.ShutdownGrace)
+	<-reaperDone
+	<-samplerDone
+	// Only close KeyDB once nothing can issue commands on it any more.
+	if err := q.Close(); err != nil {
+		slog.Warn("failed to close KeyDB client", "err", err)
+	}
 	slog.Info("shutdown complete")
 }
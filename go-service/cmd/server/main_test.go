@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyKeyDB fails the first failures pings, then answers. A negative
+// failures never answers.
+type flakyKeyDB struct {
+	failures int
+	pings    atomic.Int32
+	// onPing, when set, runs after every ping.
+	onPing func()
+}
+
+func (f *flakyKeyDB) Ping(ctx context.Context) error {
+	n := int(f.pings.Add(1))
+	if f.onPing != nil {
+		f.onPing()
+	}
+	if f.failures < 0 || n <= f.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func shortRetries(t *testing.T) {
+	t.Helper()
+	oldMin, oldMax := keydbRetryMin, keydbRetryMax
+	keydbRetryMin, keydbRetryMax = 10*time.Millisecond, 40*time.Millisecond
+	t.Cleanup(func() { keydbRetryMin, keydbRetryMax = oldMin, oldMax })
+}
+
+func TestWaitForKeyDBBecomesReady(t *testing.T) {
+	shortRetries(t)
+	db := &flakyKeyDB{failures: 3}
+	if err := waitForKeyDB(context.Background(), db, time.Minute); err != nil {
+		t.Fatalf("waitForKeyDB: %v", err)
+	}
+	if n := db.pings.Load(); n != 4 {
+		t.Errorf("pinged %d times, want 4", n)
+	}
+}
+
+func TestWaitForKeyDBZeroTimeoutTriesOnce(t *testing.T) {
+	shortRetries(t)
+	db := &flakyKeyDB{failures: 1}
+	if err := waitForKeyDB(context.Background(), db, 0); err == nil {
+		t.Error("waitForKeyDB succeeded although the only ping failed")
+	}
+	if n := db.pings.Load(); n != 1 {
+		t.Errorf("pinged %d times, want 1", n)
+	}
+}
+
+func TestWaitForKeyDBGivesUpAtDeadline(t *testing.T) {
+	shortRetries(t)
+	db := &flakyKeyDB{failures: -1}
+	const timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	err := waitForKeyDB(context.Background(), db, timeout)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("waitForKeyDB succeeded against an unreachable KeyDB")
+	}
+	if elapsed > timeout+keydbRetryMax {
+		t.Errorf("gave up after %v, want within %v", elapsed, timeout)
+	}
+	if n := db.pings.Load(); n < 2 {
+		t.Errorf("pinged %d times before giving up", n)
+	}
+}
+
+func TestWaitForKeyDBStopsOnCancel(t *testing.T) {
+	shortRetries(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := &flakyKeyDB{failures: -1, onPing: cancel}
+
+	start := time.Now()
+	err := waitForKeyDB(ctx, db, time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned %v after cancel", elapsed)
+	}
+	if n := db.pings.Load(); n != 1 {
+		t.Errorf("pinged %d times after cancel, want 1", n)
+	}
+}
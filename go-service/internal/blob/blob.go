@@ -0,0 +1,98 @@
+package blob
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get when no blob exists for the given hash.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps firmware binaries keyed by the hex sha256 of their contents.
+type Store interface {
+	Put(ctx context.Context, sha256 string, data []byte) error
+	Get(ctx context.Context, sha256 string) (io.ReadCloser, error)
+	Exists(ctx context.Context, sha256 string) (bool, error)
+}
+
+// FSStore stores blobs as files under a root directory, sharded by the first
+// two hex characters of the hash: {root}/ab/abcdef….
+type FSStore struct {
+	root string
+}
+
+func NewFS(root string) (*FSStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &FSStore{root: root}, nil
+}
+
+// Put writes data under sha256. Writing a hash that already exists is a no-op,
+// so identical binaries are stored once.
+func (s *FSStore) Put(ctx context.Context, sha256 string, data []byte) error {
+	path, err := s.path(sha256)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename so readers never see a partial blob.
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *FSStore) Get(ctx context.Context, sha256 string) (io.ReadCloser, error) {
+	path, err := s.path(sha256)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *FSStore) Exists(ctx context.Context, sha256 string) (bool, error) {
+	path, err := s.path(sha256)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// path validates the hash before it is used to build a filesystem path.
+func (s *FSStore) path(sha256 string) (string, error) {
+	if b, err := hex.DecodeString(sha256); err != nil || len(b) != 32 {
+		return "", fmt.Errorf("invalid sha256 %q", sha256)
+	}
+	sha256 = strings.ToLower(sha256)
+	return filepath.Join(s.root, sha256[:2], sha256), nil
+}
@@ -0,0 +1,114 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readBlob(t *testing.T, s *FSStore, sha string) []byte {
+	t.Helper()
+	rc, err := s.Get(context.Background(), sha)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", sha, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFSStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s, err := NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("firmware image")
+	sha := hashOf(data)
+
+	if ok, err := s.Exists(ctx, sha); err != nil || ok {
+		t.Fatalf("Exists before Put = %v, %v", ok, err)
+	}
+	if _, err := s.Get(ctx, sha); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get before Put: err = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Put(ctx, sha, data); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Exists(ctx, sha); err != nil || !ok {
+		t.Fatalf("Exists after Put = %v, %v", ok, err)
+	}
+	if got := readBlob(t, s, sha); string(got) != string(data) {
+		t.Errorf("Get = %q, want %q", got, data)
+	}
+	if _, err := os.Stat(filepath.Join(root, sha[:2], sha)); err != nil {
+		t.Errorf("blob not stored under its shard directory: %v", err)
+	}
+
+	// Upper-case hashes address the same blob.
+	if ok, _ := s.Exists(ctx, strings.ToUpper(sha)); !ok {
+		t.Error("Exists is case-sensitive")
+	}
+}
+
+func TestFSStoreDedup(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s, err := NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("firmware image")
+	sha := hashOf(data)
+
+	if err := s.Put(ctx, sha, data); err != nil {
+		t.Fatal(err)
+	}
+	// A second Put of the same hash must not rewrite the stored blob.
+	if err := s.Put(ctx, sha, []byte("different bytes")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, s, sha); string(got) != string(data) {
+		t.Errorf("second Put replaced the blob: got %q", got)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, sha[:2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("shard holds %d files, want 1 (no leftover temp files)", len(entries))
+	}
+}
+
+func TestFSStoreRejectsInvalidHash(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sha := range []string{"", "abc", "../../etc/passwd", strings.Repeat("g", 64)} {
+		if err := s.Put(ctx, sha, []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded", sha)
+		}
+		if _, err := s.Get(ctx, sha); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q): err = %v, want invalid hash", sha, err)
+		}
+	}
+}
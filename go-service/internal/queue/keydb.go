@@ -2,8 +2,14 @@ package queue
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,64 +20,486 @@ const (
 	keyProcessing = "sim:jobs:processing"
 	keyStats      = "sim:stats"
 	keyHistory    = "sim:jobs:history"
+	keyTagPrefix  = "sim:jobs:tag:"
+	keyClaims     = "sim:jobs:claims"
+	keyDead       = "sim:jobs:dead"
+	keyOwners     = "sim:jobs:owners"
+	keyMaxPending = "sim:config:max_pending"
 	resultTTL     = time.Hour
+
+	// Tag indexes keep the newest tagIndexMax jobs per tag and expire once
+	// a tag has gone unused for tagIndexTTL.
+	tagIndexMax = 10000
+	tagIndexTTL = 30 * 24 * time.Hour
+
+	// dequeueWait bounds each blocking wait in Dequeue.
+	dequeueWait = time.Second
+
+	// HeartbeatTTL is how long a worker counts as alive after its last
+	// UpdateWorkerStatus. Workers must refresh well within this window.
+	HeartbeatTTL = 30 * time.Second
 )
 
+var (
+	// ErrNoResult is returned by Result when no result is stored for a job.
+	ErrNoResult = errors.New("no result stored for job")
+	// ErrQueueFull is returned by Enqueue when the pending list is at its cap.
+	ErrQueueFull = errors.New("job queue is full")
+)
+
+// enqueueScript pushes ARGV[1] onto the pending list (KEYS[1]) unless it
+// already holds as many jobs as the cap stored in KEYS[3] by SetMaxPending
+// (missing = no cap), counting rejections in the stats hash (KEYS[2]).
+// Running it as a script makes the depth check and the push atomic.
+var enqueueScript = redis.NewScript(`
+local limit = tonumber(redis.call('GET', KEYS[3]) or '0') or 0
+if limit > 0 and redis.call('LLEN', KEYS[1]) >= limit then
+	redis.call('HINCRBY', KEYS[2], 'jobs_rejected', 1)
+	return -1
+end
+return redis.call('LPUSH', KEYS[1], ARGV[1])
+`)
+
+// claimScript moves the oldest pending job (KEYS[1]) to the processing list
+// (KEYS[2]) and records its claim time ARGV[1] in KEYS[3] and its owning
+// worker ARGV[2] in KEYS[4]. Doing all three in one script means a job is
+// never in processing without a claim for RequeueStale to find.
+var claimScript = redis.NewScript(`
+local raw = redis.call('LMOVE', KEYS[1], KEYS[2], 'RIGHT', 'LEFT')
+if not raw then
+	return false
+end
+redis.call('ZADD', KEYS[3], ARGV[1], raw)
+redis.call('HSET', KEYS[4], raw, ARGV[2])
+return raw
+`)
+
+// requeueScript moves the abandoned job ARGV[1] out of the processing list
+// (KEYS[1]) and pushes its updated payload ARGV[2] onto KEYS[4], the pending
+// or dead-letter list, dropping its claim (KEYS[2]) and owner (KEYS[3]). It
+// returns 0 without pushing if the job was no longer in processing, i.e. it
+// was acked or reaped concurrently. As one script the job is never left in
+// neither list.
+var requeueScript = redis.NewScript(`
+local removed = redis.call('LREM', KEYS[1], 1, ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+if removed == 0 then
+	return 0
+end
+redis.call('LPUSH', KEYS[4], ARGV[2])
+return 1
+`)
+
 type Queue struct {
-	rdb *redis.Client
+	rdb       *redis.Client
+	opTimeout time.Duration
 }
 
-func New(addr string) *Queue {
+// Options configures the KeyDB connection.
+type Options struct {
+	Addr     string
+	Username string // ACL user; empty uses the default user
+	Password string
+	DB       int
+
+	// TLS enables TLS. TLSCAFile overrides the system roots; TLSCertFile and
+	// TLSKeyFile supply a client certificate when both are set.
+	TLS         bool
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// OpTimeout bounds every call except the blocking Dequeue (zero disables
+	// the bound) so a stalled KeyDB cannot pin a worker indefinitely. It is
+	// also the socket read/write timeout, so values above go-redis's 3s
+	// default take effect.
+	OpTimeout time.Duration
+}
+
+// redisOptions converts o into go-redis options, loading TLS material.
+func (o Options) redisOptions() (*redis.Options, error) {
+	ro := &redis.Options{
+		Addr:     o.Addr,
+		Username: o.Username,
+		Password: o.Password,
+		DB:       o.DB,
+		// Without this go-redis ignores context deadlines on network I/O and
+		// only the socket timeouts apply.
+		ContextTimeoutEnabled: true,
+	}
+	if o.OpTimeout > 0 {
+		ro.ReadTimeout = o.OpTimeout
+		ro.WriteTimeout = o.OpTimeout
+	}
+	if !o.TLS {
+		return ro, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.TLSCAFile != "" {
+		pem, err := os.ReadFile(o.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read KeyDB CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.TLSCAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if o.TLSCertFile != "" || o.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.TLSCertFile, o.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load KeyDB client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	ro.TLSConfig = tlsCfg
+	return ro, nil
+}
+
+// New creates a queue client.
+func New(opts Options) (*Queue, error) {
+	ro, err := opts.redisOptions()
+	if err != nil {
+		return nil, err
+	}
 	return &Queue{
-		rdb: redis.NewClient(&redis.Options{Addr: addr}),
+		rdb:       redis.NewClient(ro),
+		opTimeout: opts.OpTimeout,
+	}, nil
+}
+
+func (q *Queue) opCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if q.opTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, q.opTimeout)
+}
+
+// Close releases the KeyDB connection pool. No queue method may be called
+// afterwards.
+func (q *Queue) Close() error {
+	return q.rdb.Close()
 }
 
 func (q *Queue) Ping(ctx context.Context) error {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
 	return q.rdb.Ping(ctx).Err()
 }
 
+// SetMaxPending publishes the service's cap on pending jobs, which Enqueue
+// enforces for every submitter; n <= 0 removes the cap. With several service
+// instances the last one to start wins.
+func (q *Queue) SetMaxPending(ctx context.Context, n int) error {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	if n <= 0 {
+		return q.rdb.Del(ctx, keyMaxPending).Err()
+	}
+	return q.rdb.Set(ctx, keyMaxPending, n, 0).Err()
+}
+
+// Enqueue pushes a raw job payload onto the pending list. If the list is at
+// the cap set by SetMaxPending, the job is not queued and ErrQueueFull is
+// returned.
+func (q *Queue) Enqueue(ctx context.Context, raw []byte) error {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	n, err := enqueueScript.Run(ctx, q.rdb, []string{keyPending, keyStats, keyMaxPending}, raw).Int64()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// Result returns the stored result JSON for jobID, falling back to the
+// persistent copy once the polling copy has expired.
+func (q *Queue) Result(ctx context.Context, jobID string) ([]byte, error) {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	data, err := q.rdb.Get(ctx, "sim:results:"+jobID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		data, err = q.rdb.Get(ctx, "sim:jobs:detail:"+jobID).Bytes()
+	}
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNoResult
+	}
+	return data, err
+}
+
+// Depths returns the lengths of the pending, processing and dead-letter
+// lists in one round trip.
+func (q *Queue) Depths(ctx context.Context) (pending, processing, dead int64, err error) {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	pipe := q.rdb.Pipeline()
+	p := pipe.LLen(ctx, keyPending)
+	pr := pipe.LLen(ctx, keyProcessing)
+	d := pipe.LLen(ctx, keyDead)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, 0, err
+	}
+	return p.Val(), pr.Val(), d.Val(), nil
+}
+
 // Dequeue blocks until a job is available, moves it to the processing list,
-// and returns the raw JSON bytes.
-func (q *Queue) Dequeue(ctx context.Context) ([]byte, error) {
-	res, err := q.rdb.BRPopLPush(ctx, keyPending, keyProcessing, 0).Bytes()
+// and returns the raw JSON bytes. The move and the claim records in
+// sim:jobs:claims and sim:jobs:owners happen atomically (see claimScript),
+// so abandoned jobs can always be found by RequeueStale.
+//
+// go-redis cannot interrupt a blocking read, so Dequeue waits in slices of
+// dequeueWait and returns ctx's error within one slice of cancellation.
+func (q *Queue) Dequeue(ctx context.Context, workerID string) ([]byte, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Wait for a job without taking it: moving the tail of the list onto
+		// its own tail leaves the list unchanged. Scripts cannot block.
+		err := q.rdb.BLMove(ctx, keyPending, keyPending, "RIGHT", "RIGHT", dequeueWait).Err()
+		if errors.Is(err, redis.Nil) {
+			continue // nothing arrived within dequeueWait
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw, err := q.claim(ctx, workerID)
+		if errors.Is(err, redis.Nil) {
+			continue // another worker claimed it first
+		}
+		if err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}
+}
+
+func (q *Queue) claim(ctx context.Context, workerID string) ([]byte, error) {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	keys := []string{keyPending, keyProcessing, keyClaims, keyOwners}
+	raw, err := claimScript.Run(ctx, q.rdb, keys, time.Now().Unix(), workerID).Text()
 	if err != nil {
 		return nil, err
 	}
-	return res, nil
+	return []byte(raw), nil
 }
 
 // AckDone removes the job from the processing list after it has been handled.
 func (q *Queue) AckDone(ctx context.Context, raw []byte) {
-	q.rdb.LRem(ctx, keyProcessing, 1, string(raw))
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	pipe := q.rdb.Pipeline()
+	pipe.LRem(ctx, keyProcessing, 1, string(raw))
+	pipe.ZRem(ctx, keyClaims, string(raw))
+	pipe.HDel(ctx, keyOwners, string(raw))
+	pipe.Exec(ctx)
+}
+
+// RequeueStale moves abandoned jobs from the processing list back to
+// pending, incrementing their "attempts" field. A job is abandoned when it
+// was claimed longer than olderThan ago, or when the heartbeat of the worker
+// that claimed it has expired. Jobs that reach maxAttempts go to
+// sim:jobs:dead instead and are returned so the caller can record a failure
+// result for them.
+func (q *Queue) RequeueStale(ctx context.Context, olderThan time.Duration, maxAttempts int) ([][]byte, error) {
+	stale, err := q.abandoned(ctx, olderThan)
+	if err != nil {
+		return nil, err
+	}
+
+	var dead [][]byte
+	for _, raw := range stale {
+		updated, attempts, err := bumpAttempts([]byte(raw))
+		if err != nil {
+			// Unparseable payloads can never succeed; dead-letter them as-is.
+			updated, attempts = []byte(raw), maxAttempts
+		}
+		dest := keyPending
+		if attempts >= maxAttempts {
+			dest = keyDead
+		}
+
+		moved, err := q.requeue(ctx, raw, updated, dest)
+		if err != nil {
+			return dead, err
+		}
+		if moved && dest == keyDead {
+			dead = append(dead, updated)
+		}
+	}
+	return dead, nil
+}
+
+// requeue runs requeueScript for one job under its own op timeout, so a long
+// scan cannot starve the jobs at its end.
+func (q *Queue) requeue(ctx context.Context, raw string, updated []byte, dest string) (bool, error) {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	keys := []string{keyProcessing, keyClaims, keyOwners, dest}
+	n, err := requeueScript.Run(ctx, q.rdb, keys, raw, updated).Int64()
+	return n == 1, err
+}
+
+// abandoned returns the claimed payloads that are older than olderThan or
+// whose owning worker no longer has a live heartbeat.
+func (q *Queue) abandoned(ctx context.Context, olderThan time.Duration) ([]string, error) {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	claims, err := q.rdb.ZRangeWithScores(ctx, keyClaims, 0, -1).Result()
+	if err != nil || len(claims) == 0 {
+		return nil, err
+	}
+	raws := make([]string, len(claims))
+	for i, c := range claims {
+		raws[i] = c.Member.(string)
+	}
+	owners, err := q.rdb.HMGet(ctx, keyOwners, raws...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := float64(time.Now().Add(-olderThan).Unix())
+	alive := map[string]bool{}
+	var out []string
+	for i, c := range claims {
+		if c.Score <= cutoff {
+			out = append(out, raws[i])
+			continue
+		}
+		owner, _ := owners[i].(string)
+		if owner == "" {
+			continue
+		}
+		live, seen := alive[owner]
+		if !seen {
+			n, err := q.rdb.Exists(ctx, workerKey(owner)).Result()
+			if err != nil {
+				return nil, err
+			}
+			live = n > 0
+			alive[owner] = live
+		}
+		if !live {
+			out = append(out, raws[i])
+		}
+	}
+	return out, nil
+}
+
+// bumpAttempts increments the "attempts" field of a raw job payload,
+// leaving every other field untouched.
+func bumpAttempts(raw []byte) ([]byte, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, 0, err
+	}
+	var attempts int
+	if v, ok := fields["attempts"]; ok {
+		if err := json.Unmarshal(v, &attempts); err != nil {
+			return nil, 0, err
+		}
+	}
+	attempts++
+	fields["attempts"] = json.RawMessage(strconv.Itoa(attempts))
+	out, err := json.Marshal(fields)
+	return out, attempts, err
 }
 
 // StoreResult writes the result to three places:
 //   - sim:results:{id}   — with TTL (for clients polling)
 //   - sim:jobs:detail:{id} — without TTL (persistent log)
 //   - sim:jobs:history   — sorted set, score = unix timestamp
-func (q *Queue) StoreResult(ctx context.Context, jobID string, result any) error {
+//
+// Each tag additionally indexes the job in sim:jobs:tag:{tag}, a sorted set
+// with the same scoring as the history, read back by JobsByTag.
+func (q *Queue) StoreResult(ctx context.Context, jobID string, result any, tags ...string) error {
 	data, err := json.Marshal(result)
 	if err != nil {
 		return err
 	}
 	now := float64(time.Now().Unix())
 
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
 	pipe := q.rdb.Pipeline()
 	pipe.Set(ctx, "sim:results:"+jobID, data, resultTTL)
 	pipe.Set(ctx, "sim:jobs:detail:"+jobID, data, 0)
 	pipe.ZAdd(ctx, keyHistory, redis.Z{Score: now, Member: jobID})
+	for _, tag := range tags {
+		key := keyTagPrefix + tag
+		pipe.ZAdd(ctx, key, redis.Z{Score: now, Member: jobID})
+		pipe.ZRemRangeByRank(ctx, key, 0, -tagIndexMax-1)
+		pipe.Expire(ctx, key, tagIndexTTL)
+	}
 	_, err = pipe.Exec(ctx)
 	return err
 }
 
-// UpdateWorkerStatus sets a heartbeat key with TTL for a worker.
-func (q *Queue) UpdateWorkerStatus(ctx context.Context, workerID, status string) {
-	key := fmt.Sprintf("sim:worker:%s", workerID)
-	q.rdb.Set(ctx, key, status, 30*time.Second)
+// JobsByTag returns the IDs of finished jobs carrying tag, newest first.
+// A non-positive limit returns every indexed job.
+func (q *Queue) JobsByTag(ctx context.Context, tag string, limit int) ([]string, error) {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	return q.rdb.ZRevRange(ctx, keyTagPrefix+tag, 0, int64(limit)-1).Result()
+}
+
+func workerKey(workerID string) string {
+	return fmt.Sprintf("sim:worker:%s", workerID)
+}
+
+// Workers counts the workers with a live heartbeat, across all instances, by
+// their published status. It SCANs the heartbeat keys, so it is meant for
+// periodic sampling rather than per-request use.
+func (q *Queue) Workers(ctx context.Context) (idle, running int64, err error) {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	var keys []string
+	iter := q.rdb.Scan(ctx, 0, workerKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		if key := iter.Val(); !strings.HasSuffix(key, ":version") {
+			keys = append(keys, key)
+		}
+	}
+	if err := iter.Err(); err != nil || len(keys) == 0 {
+		return 0, 0, err
+	}
+	statuses, err := q.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, s := range statuses {
+		// Keys that expired since the scan come back nil and are skipped.
+		switch s {
+		case "idle":
+			idle++
+		case "running":
+			running++
+		}
+	}
+	return idle, running, nil
+}
+
+// UpdateWorkerStatus sets a heartbeat key with TTL for a worker, alongside
+// sim:worker:{id}:version holding the emulator build the worker runs.
+func (q *Queue) UpdateWorkerStatus(ctx context.Context, workerID, status, version string) {
+	key := workerKey(workerID)
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
+	pipe := q.rdb.Pipeline()
+	pipe.Set(ctx, key, status, HeartbeatTTL)
+	pipe.Set(ctx, key+":version", version, HeartbeatTTL)
+	pipe.Exec(ctx)
 }
 
 // IncrStats increments aggregate counters.
 func (q *Queue) IncrStats(ctx context.Context, field string, by int64) {
+	ctx, cancel := q.opCtx(ctx)
+	defer cancel()
 	q.rdb.HIncrBy(ctx, keyStats, field, by)
 }
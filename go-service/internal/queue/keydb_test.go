@@ -0,0 +1,526 @@
+package queue
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestQueue connects to the KeyDB at KEYDB_TEST_ADDR and empties database
+// KEYDB_TEST_DB (default 15) before and after the test. Tests that need a
+// server are skipped when KEYDB_TEST_ADDR is unset.
+func newTestQueue(t *testing.T) *Queue {
+	t.Helper()
+	addr := os.Getenv("KEYDB_TEST_ADDR")
+	if addr == "" {
+		t.Skip("KEYDB_TEST_ADDR not set")
+	}
+	db := 15
+	if v := os.Getenv("KEYDB_TEST_DB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			t.Fatalf("KEYDB_TEST_DB: %v", err)
+		}
+		db = n
+	}
+	q, err := New(Options{Addr: addr, DB: db, OpTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := q.rdb.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("flush test db: %v", err)
+	}
+	t.Cleanup(func() {
+		q.rdb.FlushDB(ctx)
+		q.Close()
+	})
+	return q
+}
+
+func TestStoreResultTagRoundTrip(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+
+	results := map[string][]string{
+		"job-a": {"lab1", "blink"},
+		"job-b": {"lab1"},
+		"job-c": nil,
+	}
+	for id, tags := range results {
+		if err := q.StoreResult(ctx, id, map[string]string{"job_id": id}, tags...); err != nil {
+			t.Fatalf("StoreResult(%s): %v", id, err)
+		}
+	}
+
+	for tag, want := range map[string][]string{
+		"lab1":    {"job-a", "job-b"},
+		"blink":   {"job-a"},
+		"missing": {},
+	} {
+		got, err := q.JobsByTag(ctx, tag, 0)
+		if err != nil {
+			t.Fatalf("JobsByTag(%s): %v", tag, err)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("JobsByTag(%s) = %v, want %v", tag, got, want)
+		}
+	}
+	if got, _ := q.JobsByTag(ctx, "lab1", 1); len(got) != 1 {
+		t.Errorf("JobsByTag with limit 1 returned %d jobs", len(got))
+	}
+	if ttl := q.rdb.TTL(ctx, keyTagPrefix+"lab1").Val(); ttl <= 0 {
+		t.Errorf("tag index has no TTL (%v)", ttl)
+	}
+
+	data, err := q.Result(ctx, "job-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res map[string]string
+	if err := json.Unmarshal(data, &res); err != nil || res["job_id"] != "job-a" {
+		t.Errorf("Result(job-a) = %s, %v", data, err)
+	}
+}
+
+func TestBumpAttempts(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		attempts int
+	}{
+		{`{"id":"j"}`, 1},
+		{`{"id":"j","attempts":2}`, 3},
+		{`{"id":"j","future_field":{"x":[1,2]},"attempts":0}`, 1},
+	} {
+		out, attempts, err := bumpAttempts([]byte(tc.in))
+		if err != nil {
+			t.Fatalf("bumpAttempts(%s): %v", tc.in, err)
+		}
+		if attempts != tc.attempts {
+			t.Errorf("bumpAttempts(%s) attempts = %d, want %d", tc.in, attempts, tc.attempts)
+		}
+		var before, after map[string]json.RawMessage
+		json.Unmarshal([]byte(tc.in), &before)
+		if err := json.Unmarshal(out, &after); err != nil {
+			t.Fatalf("bumpAttempts(%s) produced invalid JSON %s", tc.in, out)
+		}
+		if string(after["attempts"]) != strconv.Itoa(tc.attempts) {
+			t.Errorf("bumpAttempts(%s) = %s", tc.in, out)
+		}
+		delete(before, "attempts")
+		delete(after, "attempts")
+		for k, v := range before {
+			if string(after[k]) != string(v) {
+				t.Errorf("bumpAttempts(%s) changed field %s to %s", tc.in, k, after[k])
+			}
+		}
+	}
+
+	for _, in := range []string{`not json`, `[1,2]`, `{"attempts":"two"}`} {
+		if _, _, err := bumpAttempts([]byte(in)); err == nil {
+			t.Errorf("bumpAttempts(%s) succeeded", in)
+		}
+	}
+}
+
+func TestDequeueRecordsClaim(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, []byte(`{"id":"j1"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := q.Dequeue(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != `{"id":"j1"}` {
+		t.Fatalf("Dequeue = %s", raw)
+	}
+	if n := q.rdb.LLen(ctx, keyProcessing).Val(); n != 1 {
+		t.Errorf("processing holds %d jobs, want 1", n)
+	}
+	if err := q.rdb.ZScore(ctx, keyClaims, string(raw)).Err(); err != nil {
+		t.Errorf("no claim recorded: %v", err)
+	}
+	if owner := q.rdb.HGet(ctx, keyOwners, string(raw)).Val(); owner != "w1" {
+		t.Errorf("owner = %q, want w1", owner)
+	}
+
+	q.AckDone(ctx, raw)
+	if n := q.rdb.LLen(ctx, keyProcessing).Val() + q.rdb.ZCard(ctx, keyClaims).Val() + q.rdb.HLen(ctx, keyOwners).Val(); n != 0 {
+		t.Errorf("AckDone left %d processing/claim/owner entries", n)
+	}
+}
+
+func TestDequeueReturnsAfterCancel(t *testing.T) {
+	q := newTestQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := q.Dequeue(ctx, "w1")
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed > 100*time.Millisecond+2*dequeueWait {
+		t.Errorf("Dequeue on an empty queue returned %v after cancel", elapsed-100*time.Millisecond)
+	}
+}
+
+func TestRequeueStaleThenDeadLetter(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	const maxAttempts = 2
+	if err := q.Enqueue(ctx, []byte(`{"id":"j1"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		// "lost" never heartbeats, so its claim counts as abandoned at once.
+		if _, err := q.Dequeue(ctx, "lost"); err != nil {
+			t.Fatal(err)
+		}
+		dead, err := q.RequeueStale(ctx, time.Hour, maxAttempts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, processing, deadLen, err := q.Depths(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if processing != 0 {
+			t.Fatalf("attempt %d: %d jobs left in processing", attempt, processing)
+		}
+
+		if attempt < maxAttempts {
+			if len(dead) != 0 || pending != 1 || deadLen != 0 {
+				t.Fatalf("attempt %d: dead=%d pending=%d deadLen=%d, want requeue", attempt, len(dead), pending, deadLen)
+			}
+			continue
+		}
+		if len(dead) != 1 || pending != 0 || deadLen != 1 {
+			t.Fatalf("attempt %d: dead=%d pending=%d deadLen=%d, want dead-letter", attempt, len(dead), pending, deadLen)
+		}
+		var job struct {
+			ID       string `json:"id"`
+			Attempts int    `json:"attempts"`
+		}
+		if err := json.Unmarshal(dead[0], &job); err != nil || job.ID != "j1" || job.Attempts != maxAttempts {
+			t.Errorf("dead-lettered payload = %s (%v)", dead[0], err)
+		}
+	}
+	if n := q.rdb.ZCard(ctx, keyClaims).Val() + q.rdb.HLen(ctx, keyOwners).Val(); n != 0 {
+		t.Errorf("%d claim/owner entries left behind", n)
+	}
+}
+
+func TestRequeueStaleKeepsJobsOfLiveWorkers(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	q.UpdateWorkerStatus(ctx, "alive", "running", "test")
+	if err := q.Enqueue(ctx, []byte(`{"id":"j1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Dequeue(ctx, "alive"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.RequeueStale(ctx, time.Hour, 3); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.rdb.LLen(ctx, keyProcessing).Val(); n != 1 {
+		t.Fatalf("job of a live worker was requeued (processing=%d)", n)
+	}
+
+	// Past olderThan the job is abandoned even though the worker heartbeats.
+	if _, err := q.RequeueStale(ctx, -time.Second, 3); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.rdb.LLen(ctx, keyPending).Val(); n != 1 {
+		t.Errorf("stale job was not requeued (pending=%d)", n)
+	}
+}
+
+func TestRequeueStaleSkipsAckedJobs(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	// A claim whose job already left processing, as when AckDone races the
+	// reaper: the claim is dropped and nothing is pushed.
+	raw := `{"id":"j1"}`
+	q.rdb.ZAdd(ctx, keyClaims, redis.Z{Score: 0, Member: raw})
+	q.rdb.HSet(ctx, keyOwners, raw, "lost")
+
+	dead, err := q.RequeueStale(ctx, time.Hour, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, processing, deadLen, err := q.Depths(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 0 || pending+processing+deadLen != 0 {
+		t.Errorf("acked job resurfaced: dead=%d pending=%d processing=%d deadLen=%d", len(dead), pending, processing, deadLen)
+	}
+	if n := q.rdb.ZCard(ctx, keyClaims).Val() + q.rdb.HLen(ctx, keyOwners).Val(); n != 0 {
+		t.Errorf("%d claim/owner entries left behind", n)
+	}
+}
+
+func TestUpdateWorkerStatusRecordsVersion(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	q.UpdateWorkerStatus(ctx, "w1", "idle", "v1.4.2")
+
+	key := workerKey("w1") + ":version"
+	if v := q.rdb.Get(ctx, key).Val(); v != "v1.4.2" {
+		t.Errorf("%s = %q, want v1.4.2", key, v)
+	}
+	if ttl := q.rdb.TTL(ctx, key).Val(); ttl <= 0 || ttl > HeartbeatTTL {
+		t.Errorf("%s TTL = %v, want within (0, %v]", key, ttl, HeartbeatTTL)
+	}
+	if v := q.rdb.Get(ctx, workerKey("w1")).Val(); v != "idle" {
+		t.Errorf("heartbeat status = %q, want idle", v)
+	}
+}
+
+func TestWorkersCountsLiveHeartbeats(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	q.UpdateWorkerStatus(ctx, "a-worker-0", "idle", "v1")
+	q.UpdateWorkerStatus(ctx, "a-worker-1", "running", "v1")
+	q.UpdateWorkerStatus(ctx, "b-worker-0", "running", "v2")
+
+	idle, running, err := q.Workers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idle != 1 || running != 2 {
+		t.Errorf("Workers = %d idle, %d running; want 1, 2", idle, running)
+	}
+
+	q.rdb.Del(ctx, workerKey("a-worker-1"))
+	if _, running, _ := q.Workers(ctx); running != 1 {
+		t.Errorf("expired heartbeat still counted: %d running", running)
+	}
+}
+
+func TestEnqueueRejectsWhenFull(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	const limit = 3
+	if err := q.SetMaxPending(ctx, limit); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < limit; i++ {
+		if err := q.Enqueue(ctx, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("enqueue %d of %d: %v", i+1, limit, err)
+		}
+	}
+	if err := q.Enqueue(ctx, []byte("over")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("enqueue past the cap: err = %v, want ErrQueueFull", err)
+	}
+	if n := q.rdb.LLen(ctx, keyPending).Val(); n != limit {
+		t.Errorf("pending holds %d jobs, want %d", n, limit)
+	}
+	if n := q.rdb.HGet(ctx, keyStats, "jobs_rejected").Val(); n != "1" {
+		t.Errorf("jobs_rejected = %q, want 1", n)
+	}
+
+	// Removing the cap accepts jobs again.
+	if err := q.SetMaxPending(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ctx, []byte("uncapped")); err != nil {
+		t.Errorf("enqueue without a cap: %v", err)
+	}
+}
+
+// writeCert writes a self-signed certificate and its key as PEM files.
+func writeCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "keydb-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestRedisOptions(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir())
+
+	ro, err := Options{Addr: "keydb:6379", Username: "sim", Password: "secret", DB: 3}.redisOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ro.Addr != "keydb:6379" || ro.Username != "sim" || ro.Password != "secret" || ro.DB != 3 {
+		t.Errorf("plain options = %+v", ro)
+	}
+	if ro.TLSConfig != nil {
+		t.Error("TLS configured although Options.TLS is false")
+	}
+	if !ro.ContextTimeoutEnabled {
+		t.Error("context deadlines are not applied to network I/O")
+	}
+	if ro.ReadTimeout != 0 || ro.WriteTimeout != 0 {
+		t.Errorf("socket timeouts %v/%v set without OpTimeout", ro.ReadTimeout, ro.WriteTimeout)
+	}
+
+	ro, err = Options{Addr: "keydb:6379", OpTimeout: 30 * time.Second}.redisOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ro.ReadTimeout != 30*time.Second || ro.WriteTimeout != 30*time.Second {
+		t.Errorf("socket timeouts = %v/%v, want OpTimeout", ro.ReadTimeout, ro.WriteTimeout)
+	}
+
+	ro, err = Options{
+		Addr:        "keydb:6380",
+		Username:    "sim",
+		DB:          2,
+		TLS:         true,
+		TLSCAFile:   certFile,
+		TLSCertFile: certFile,
+		TLSKeyFile:  keyFile,
+	}.redisOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ro.Username != "sim" || ro.DB != 2 {
+		t.Errorf("TLS options lost Username/DB: %+v", ro)
+	}
+	if ro.TLSConfig == nil {
+		t.Fatal("TLSConfig not set")
+	}
+	if ro.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion = %x, want TLS 1.2", ro.TLSConfig.MinVersion)
+	}
+	if ro.TLSConfig.RootCAs == nil {
+		t.Error("RootCAs not loaded from TLSCAFile")
+	}
+	if len(ro.TLSConfig.Certificates) != 1 {
+		t.Errorf("loaded %d client certificates, want 1", len(ro.TLSConfig.Certificates))
+	}
+
+	// TLS without files uses the system roots and no client certificate.
+	ro, err = Options{Addr: "keydb:6380", TLS: true}.redisOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ro.TLSConfig == nil || ro.TLSConfig.RootCAs != nil || len(ro.TLSConfig.Certificates) != 0 {
+		t.Errorf("bare TLS config = %+v", ro.TLSConfig)
+	}
+
+	for name, opts := range map[string]Options{
+		"missing CA":     {TLS: true, TLSCAFile: filepath.Join(t.TempDir(), "none.pem")},
+		"CA without PEM": {TLS: true, TLSCAFile: keyFile},
+		"cert sans key":  {TLS: true, TLSCertFile: certFile},
+	} {
+		if _, err := opts.redisOptions(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+// stalledServer accepts connections and reads from them but never replies,
+// like a KeyDB that hangs mid-command.
+func stalledServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestStalledCallsHonourDeadlines(t *testing.T) {
+	addr := stalledServer(t)
+
+	// go-redis's own socket timeout is 3s; both bounds below must cut in
+	// well before it.
+	for name, tc := range map[string]struct {
+		opTimeout time.Duration
+		deadline  time.Duration
+	}{
+		"op timeout":      {opTimeout: 200 * time.Millisecond},
+		"caller deadline": {opTimeout: 30 * time.Second, deadline: 200 * time.Millisecond},
+	} {
+		q, err := New(Options{Addr: addr, OpTimeout: tc.opTimeout})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		if tc.deadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tc.deadline)
+			defer cancel()
+		}
+
+		start := time.Now()
+		err = q.Ping(ctx)
+		elapsed := time.Since(start)
+		q.Close()
+		if err == nil {
+			t.Errorf("%s: Ping against a stalled server succeeded", name)
+		}
+		if elapsed > time.Second {
+			t.Errorf("%s: Ping returned after %v", name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package simulator
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// Memory map of the emulated STM32F103C8 (see src/memory/memory.h).
+const (
+	flashBase = 0x08000000
+	flashSize = 64 * 1024
+	sramBase  = 0x20000000
+	sramSize  = 20 * 1024
+)
+
+// ValidateImage checks that data looks like a raw Cortex-M flash image the
+// simulator can boot: a vector table whose initial stack pointer lies in SRAM
+// and whose reset handler is a Thumb address in flash. ELF files are rejected
+// with a hint, since the simulator loads raw images only.
+func ValidateImage(data []byte) error {
+	if bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		f, err := elf.NewFile(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("malformed ELF image: %v", err)
+		}
+		if f.Machine != elf.EM_ARM {
+			return fmt.Errorf("ELF image targets %s, expected ARM Cortex-M (EM_ARM)", f.Machine)
+		}
+		return fmt.Errorf("ELF images are not supported; convert with arm-none-eabi-objcopy -O binary")
+	}
+
+	if len(data) > flashSize {
+		return fmt.Errorf("image is %d bytes, larger than the %d byte flash", len(data), flashSize)
+	}
+	if len(data) < 8 {
+		return fmt.Errorf("image is %d bytes, too small to hold a vector table", len(data))
+	}
+	sp := binary.LittleEndian.Uint32(data[0:4])
+	reset := binary.LittleEndian.Uint32(data[4:8])
+	if sp < sramBase || sp > sramBase+sramSize {
+		return fmt.Errorf("initial stack pointer 0x%08x is outside SRAM (0x%08x-0x%08x); not a Cortex-M vector table",
+			sp, sramBase, sramBase+sramSize)
+	}
+	if reset&1 == 0 {
+		return fmt.Errorf("reset vector 0x%08x is not a Thumb address", reset)
+	}
+	if addr := reset &^ 1; addr < flashBase || addr >= flashBase+uint32(len(data)) {
+		return fmt.Errorf("reset vector 0x%08x points outside the image (0x%08x-0x%08x)",
+			reset, flashBase, flashBase+uint32(len(data)))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readExample(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "examples", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// vectorTable returns a minimal raw image of size bytes whose first two
+// words are sp and reset.
+func vectorTable(size int, sp, reset uint32) []byte {
+	img := make([]byte, size)
+	binary.LittleEndian.PutUint32(img[0:4], sp)
+	binary.LittleEndian.PutUint32(img[4:8], reset)
+	return img
+}
+
+func TestValidateImageAcceptsFirmware(t *testing.T) {
+	if err := ValidateImage(readExample(t, "firmware.bin")); err != nil {
+		t.Errorf("examples/firmware.bin rejected: %v", err)
+	}
+	if err := ValidateImage(vectorTable(256, 0x20005000, 0x08000041)); err != nil {
+		t.Errorf("minimal vector table rejected: %v", err)
+	}
+}
+
+func TestValidateImageRejects(t *testing.T) {
+	armELF := readExample(t, "stub.elf")
+	x86ELF := append([]byte(nil), armELF...)
+	binary.LittleEndian.PutUint16(x86ELF[18:20], 62) // e_machine = EM_X86_64
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"ARM ELF", readExample(t, "firmware.elf"), "arm-none-eabi-objcopy -O binary"},
+		{"ARM ELF stub", armELF, "arm-none-eabi-objcopy -O binary"},
+		{"x86 ELF", x86ELF, "expected ARM Cortex-M"},
+		{"truncated ELF", armELF[:20], "malformed ELF"},
+		{"empty", nil, "too small"},
+		{"too large", vectorTable(flashSize+1, 0x20005000, 0x08000041), "larger than"},
+		{"SP outside SRAM", vectorTable(256, 0x10000000, 0x08000041), "stack pointer"},
+		{"ARM reset vector", vectorTable(256, 0x20005000, 0x08000040), "not a Thumb address"},
+		{"reset past image", vectorTable(256, 0x20005000, 0x08001001), "outside the image"},
+		{"text file", []byte("this is not firmware at all"), "stack pointer"},
+	} {
+		err := ValidateImage(tc.data)
+		if err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		} else if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error %q does not mention %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // SimOutput is the JSON structure produced by stm32sim --json.
@@ -58,6 +59,13 @@ type Config struct {
 	BinaryPath string
 	MaxCycles  uint64
 	Timeout    time.Duration
+	// Version identifies the simulator build; it is recorded with each result.
+	Version string
+	// MaxOutputBytes caps the log output (stderr lines, UART text and UART
+	// events) kept per job so a runaway firmware cannot fill KeyDB. Zero
+	// means unlimited. The raw stdout is bounded separately by
+	// maxStdoutBytes.
+	MaxOutputBytes int
 }
 
 // Run executes the simulator on the given firmware binary and returns the result.
@@ -74,7 +82,8 @@ func Run(ctx context.Context, cfg Config, firmwarePath string) RunResult {
 
 	cmd := exec.CommandContext(runCtx, cfg.BinaryPath, args...)
 
-	var stdoutBuf, stderrBuf bytes.Buffer
+	stdoutBuf := cappedBuffer{max: maxStdoutBytes}
+	stderrBuf := cappedBuffer{max: cfg.MaxOutputBytes}
 	cmd.Stdout = &stdoutBuf
 	cmd.Stderr = &stderrBuf
 
@@ -93,7 +102,7 @@ func Run(ctx context.Context, cfg Config, firmwarePath string) RunResult {
 	}
 
 	// Collect stderr lines as errors, skipping known startup diagnostics.
-	stderrStr := strings.TrimSpace(stderrBuf.String())
+	stderrStr := strings.TrimSpace(stderrBuf.buf.String())
 	var stderrLines []string
 	for _, line := range strings.Split(stderrStr, "\n") {
 		line = strings.TrimSpace(line)
@@ -115,25 +124,99 @@ func Run(ctx context.Context, cfg Config, firmwarePath string) RunResult {
 			result.Status = "error"
 			result.ErrorMessage = err.Error()
 		}
-		result.Sim.Errors = stderrLines
+		limitOutput(&result.Sim, stderrLines, cfg.MaxOutputBytes, stderrBuf.dropped)
+		return result
+	}
+
+	if stdoutBuf.dropped {
+		result.Status = "error"
+		result.ErrorMessage = fmt.Sprintf("simulator output exceeded %d bytes", stdoutBuf.max)
+		limitOutput(&result.Sim, stderrLines, cfg.MaxOutputBytes, stderrBuf.dropped)
 		return result
 	}
 
 	// Parse stdout JSON
 	var simOut SimOutput
-	if jsonErr := json.Unmarshal(stdoutBuf.Bytes(), &simOut); jsonErr != nil {
+	if jsonErr := json.Unmarshal(stdoutBuf.buf.Bytes(), &simOut); jsonErr != nil {
 		result.Status = "error"
 		result.ErrorMessage = fmt.Sprintf("failed to parse simulator output: %v", jsonErr)
-		result.Sim.Errors = stderrLines
+		limitOutput(&result.Sim, stderrLines, cfg.MaxOutputBytes, stderrBuf.dropped)
 		return result
 	}
 
-	simOut.Errors = stderrLines
+	limitOutput(&simOut, stderrLines, cfg.MaxOutputBytes, stderrBuf.dropped)
 	result.Status = "ok"
 	result.Sim = simOut
 	return result
 }
 
+// maxStdoutBytes bounds the simulator's JSON stdout, which cannot be trimmed
+// without breaking it; a run exceeding it fails. The simulator keeps at most
+// 256 UART events and 96 profiler slots of each kind, so a valid report
+// stays under 64KB and only a broken binary comes near this.
+const maxStdoutBytes = 1 << 20
+
+// limitOutput stores stderrLines as sim.Errors and trims the log output to
+// max bytes, keeping stderr first since it explains failures. UART events
+// count one byte each. stderrDropped reports stderr already cut short while
+// it was collected. If anything was dropped a single marker is appended.
+func limitOutput(sim *SimOutput, stderrLines []string, max int, stderrDropped bool) {
+	if max <= 0 {
+		sim.Errors = stderrLines
+		return
+	}
+	left := max
+	truncated := stderrDropped
+	var errs []string
+	for _, line := range stderrLines {
+		if len(line) > left {
+			truncated = true
+			break
+		}
+		left -= len(line)
+		errs = append(errs, line)
+	}
+	if len(sim.UartTx) > left {
+		// Cut on a rune boundary so the stored text stays valid UTF-8.
+		cut := left
+		for cut > 0 && !utf8.RuneStart(sim.UartTx[cut]) {
+			cut--
+		}
+		sim.UartTx = sim.UartTx[:cut]
+		truncated = true
+	}
+	left -= len(sim.UartTx)
+	if len(sim.UartEvents) > left {
+		sim.UartEvents = sim.UartEvents[:left]
+		truncated = true
+	}
+	if truncated {
+		errs = append(errs, fmt.Sprintf("log output truncated at %d bytes", max))
+	}
+	sim.Errors = errs
+}
+
+// cappedBuffer keeps the first max bytes written and discards the rest
+// without failing the write, so a chatty simulator is not killed by EPIPE.
+// Zero max means unlimited.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped bool // some output was discarded
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.max <= 0 {
+		return c.buf.Write(p)
+	}
+	room := max(c.max-c.buf.Len(), 0)
+	if len(p) > room {
+		c.dropped = true
+	}
+	c.buf.Write(p[:min(room, len(p))])
+	return len(p), nil
+}
+
 // WriteTempFile writes data to a temp file and returns its path.
 // Caller is responsible for removing the file.
 func WriteTempFile(data []byte) (string, error) {
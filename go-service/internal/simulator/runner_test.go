@@ -0,0 +1,161 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const truncationMarker = "log output truncated at"
+
+// fakeSimulator writes a shell script that stands in for stm32sim.
+func fakeSimulator(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stm32sim")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func markers(errs []string) int {
+	n := 0
+	for _, e := range errs {
+		if strings.HasPrefix(e, truncationMarker) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCappedBuffer(t *testing.T) {
+	c := cappedBuffer{max: 4}
+	if n, err := c.Write([]byte("abc")); n != 3 || err != nil || c.dropped {
+		t.Fatalf("write within cap: n=%d err=%v dropped=%v", n, err, c.dropped)
+	}
+	if n, err := c.Write([]byte("defg")); n != 4 || err != nil {
+		t.Fatalf("write past cap must report success: n=%d err=%v", n, err)
+	}
+	if c.buf.String() != "abcd" || !c.dropped {
+		t.Errorf("buffer = %q dropped = %v, want \"abcd\" true", c.buf.String(), c.dropped)
+	}
+
+	unlimited := cappedBuffer{}
+	unlimited.Write([]byte(strings.Repeat("x", 1000)))
+	if unlimited.buf.Len() != 1000 || unlimited.dropped {
+		t.Errorf("unlimited buffer kept %d bytes, dropped = %v", unlimited.buf.Len(), unlimited.dropped)
+	}
+}
+
+func TestLimitOutput(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		stderr        []string
+		stderrDropped bool
+		uart          string
+		events        int
+		max           int
+		wantErrs      int // kept stderr lines
+		wantUart      string
+		wantEvents    int
+		wantMarker    bool
+	}{
+		{"no cap", []string{"e1", "e2"}, false, "hello", 3, 0, 2, "hello", 3, false},
+		{"within cap", []string{"e1"}, false, "hello", 3, 100, 1, "hello", 3, false},
+		{"uart trimmed", []string{"err"}, false, "hello world", 0, 8, 1, "hello", 0, true},
+		{"events trimmed", nil, false, "ab", 10, 5, 0, "ab", 3, true},
+		{"stderr over cap", []string{"0123456789", "next"}, false, "uart", 2, 12, 1, "ua", 0, true},
+		{"stderr cut while collected", []string{"short"}, true, "", 0, 100, 1, "", 0, true},
+		{"uart cut on rune boundary", nil, false, "ab\u00e9\u20acz", 0, 4, 0, "ab\u00e9", 0, true},
+		{"uart cut inside first rune", nil, false, "\u20ac", 0, 2, 0, "", 0, true},
+	} {
+		sim := SimOutput{UartTx: tc.uart, UartEvents: make([]UartEvent, tc.events)}
+		limitOutput(&sim, tc.stderr, tc.max, tc.stderrDropped)
+
+		if got := markers(sim.Errors); got != map[bool]int{false: 0, true: 1}[tc.wantMarker] {
+			t.Errorf("%s: %d truncation markers in %q", tc.name, got, sim.Errors)
+		}
+		if got := len(sim.Errors) - markers(sim.Errors); got != tc.wantErrs {
+			t.Errorf("%s: kept %d stderr lines, want %d", tc.name, got, tc.wantErrs)
+		}
+		if sim.UartTx != tc.wantUart || len(sim.UartEvents) != tc.wantEvents {
+			t.Errorf("%s: uart %q with %d events, want %q with %d", tc.name, sim.UartTx, len(sim.UartEvents), tc.wantUart, tc.wantEvents)
+		}
+	}
+}
+
+func TestRunTruncatesLogOutput(t *testing.T) {
+	// 50 stderr lines of 21 bytes each, then a valid result with UART text.
+	bin := fakeSimulator(t, `
+i=0
+while [ $i -lt 50 ]; do
+	echo "runaway log line $((i+100))" >&2
+	i=$((i+1))
+done
+echo '{"halt_reason":"bkpt","uart_tx":"hello from firmware"}'`)
+
+	res := Run(context.Background(), Config{BinaryPath: bin, Timeout: 10 * time.Second, MaxOutputBytes: 100}, "fw.bin")
+
+	if res.Status != "ok" {
+		t.Fatalf("status = %s (%s), want ok", res.Status, res.ErrorMessage)
+	}
+	if n := markers(res.Sim.Errors); n != 1 {
+		t.Fatalf("%d truncation markers in %q, want exactly 1", n, res.Sim.Errors)
+	}
+	if last := res.Sim.Errors[len(res.Sim.Errors)-1]; !strings.HasPrefix(last, truncationMarker) {
+		t.Errorf("marker is not the last error: %q", res.Sim.Errors)
+	}
+	kept := len(res.Sim.UartTx)
+	for _, e := range res.Sim.Errors[:len(res.Sim.Errors)-1] {
+		kept += len(e)
+	}
+	if kept > 100 {
+		t.Errorf("kept %d bytes of log output, cap is 100", kept)
+	}
+
+	// Without a cap everything is kept.
+	res = Run(context.Background(), Config{BinaryPath: bin, Timeout: 10 * time.Second}, "fw.bin")
+	if len(res.Sim.Errors) != 50 || markers(res.Sim.Errors) != 0 || res.Sim.UartTx != "hello from firmware" {
+		t.Errorf("uncapped run kept %d errors, uart %q", len(res.Sim.Errors), res.Sim.UartTx)
+	}
+}
+
+func TestRunCapsStdout(t *testing.T) {
+	bin := fakeSimulator(t, `head -c 2000000 /dev/zero | tr '\0' 'x'`)
+
+	res := Run(context.Background(), Config{BinaryPath: bin, Timeout: 10 * time.Second}, "fw.bin")
+
+	if res.Status != "error" || !strings.Contains(res.ErrorMessage, fmt.Sprintf("simulator output exceeded %d bytes", maxStdoutBytes)) {
+		t.Errorf("result = {Status:%s ErrorMessage:%q}", res.Status, res.ErrorMessage)
+	}
+}
+
+// TestRunSmallLogCapKeepsFullReport checks that a log cap far below the size
+// of a full report trims the log instead of failing the run.
+func TestRunSmallLogCapKeepsFullReport(t *testing.T) {
+	var events []string
+	for i := 0; i < 256; i++ {
+		events = append(events, fmt.Sprintf(`{"cycle":%d,"dir":"tx","byte":65,"char":"A"}`, 1000000+i))
+	}
+	report := fmt.Sprintf(`{"halt_reason":"bkpt","cycles":123,"uart_tx":%q,"uart_events":[%s]}`,
+		strings.Repeat("A", 256), strings.Join(events, ","))
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(path, []byte(report), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bin := fakeSimulator(t, "cat "+path)
+
+	res := Run(context.Background(), Config{BinaryPath: bin, Timeout: 10 * time.Second, MaxOutputBytes: 100}, "fw.bin")
+
+	if res.Status != "ok" {
+		t.Fatalf("status %s (%s) for a %d byte report", res.Status, res.ErrorMessage, len(report))
+	}
+	if len(res.Sim.UartTx) != 100 || len(res.Sim.UartEvents) != 0 || markers(res.Sim.Errors) != 1 {
+		t.Errorf("log not trimmed to the cap: %d uart bytes, %d events, errors %q",
+			len(res.Sim.UartTx), len(res.Sim.UartEvents), res.Sim.Errors)
+	}
+}
@@ -2,9 +2,11 @@ package telemetry
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/metric"
@@ -14,10 +16,25 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
+// QueueDepths is a snapshot of the KeyDB job lists and of the workers with
+// a live heartbeat, across all instances.
+type QueueDepths struct {
+	Pending    int64
+	Processing int64
+	Dead       int64
+
+	WorkersIdle    int64
+	WorkersRunning int64
+}
+
 type Provider struct {
 	tracerProvider *sdktrace.TracerProvider
 	meterProvider  *sdkmetric.MeterProvider
 
+	// queueDepths is the latest sample reported by the sim.queue.depth and
+	// sim.workers.live gauges; nil while no fresh sample is available.
+	queueDepths atomic.Pointer[QueueDepths]
+
 	JobsProcessed metric.Int64Counter
 	ExecDuration  metric.Float64Histogram
 	CyclesTotal   metric.Int64Counter
@@ -84,10 +101,56 @@ func Init(ctx context.Context, endpoint string) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := p.registerQueueGauge(meter); err != nil {
+		return nil, err
+	}
 
 	return p, nil
 }
 
+// SetQueueDepths records the latest queue sample. Passing nil marks the
+// gauge stale, so nothing is reported until the next successful sample.
+func (p *Provider) SetQueueDepths(d *QueueDepths) {
+	p.queueDepths.Store(d)
+}
+
+// QueueDepths returns the latest queue sample, or nil while it is stale.
+func (p *Provider) QueueDepths() *QueueDepths {
+	return p.queueDepths.Load()
+}
+
+// registerQueueGauge exposes the cached queue depths and worker counts;
+// collection never touches KeyDB.
+func (p *Provider) registerQueueGauge(meter metric.Meter) error {
+	_, err := meter.Int64ObservableGauge("sim.queue.depth",
+		metric.WithDescription("Jobs in each KeyDB queue list, as last sampled"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			d := p.QueueDepths()
+			if d == nil {
+				return nil
+			}
+			o.Observe(d.Pending, metric.WithAttributes(attribute.String("queue", "pending")))
+			o.Observe(d.Processing, metric.WithAttributes(attribute.String("queue", "processing")))
+			o.Observe(d.Dead, metric.WithAttributes(attribute.String("queue", "dead")))
+			return nil
+		}))
+	if err != nil {
+		return err
+	}
+	_, err = meter.Int64ObservableGauge("sim.workers.live",
+		metric.WithDescription("Workers with a live heartbeat across all instances, by status, as last sampled"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			d := p.QueueDepths()
+			if d == nil {
+				return nil
+			}
+			o.Observe(d.WorkersIdle, metric.WithAttributes(attribute.String("status", "idle")))
+			o.Observe(d.WorkersRunning, metric.WithAttributes(attribute.String("status", "running")))
+			return nil
+		}))
+	return err
+}
+
 func (p *Provider) Shutdown(ctx context.Context) {
 	if p.tracerProvider != nil {
 		_ = p.tracerProvider.Shutdown(ctx)
@@ -119,5 +182,8 @@ func InitNoOp(p *Provider) error {
 		return err
 	}
 	p.ActiveWorkers, err = meter.Int64UpDownCounter("sim.workers.active")
-	return err
+	if err != nil {
+		return err
+	}
+	return p.registerQueueGauge(meter)
 }
@@ -0,0 +1,72 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// collectQueueDepths returns the sim.queue.depth points by queue name.
+func collectQueueDepths(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	return collectGauge(t, reader, "sim.queue.depth", "queue")
+}
+
+// collectGauge returns the points of the int64 gauge name, keyed by the
+// value of attribute key.
+func collectGauge(t *testing.T, reader *sdkmetric.ManualReader, name, key string) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Gauge[int64]).DataPoints {
+				v, _ := dp.Attributes.Value(attribute.Key(key))
+				out[v.AsString()] = dp.Value
+			}
+		}
+	}
+	return out
+}
+
+func TestQueueGaugeReportsCachedDepths(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(context.Background())
+
+	p := &Provider{}
+	if err := p.registerQueueGauge(mp.Meter("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := collectQueueDepths(t, reader); len(got) != 0 {
+		t.Errorf("gauge reported %v before any sample", got)
+	}
+
+	p.SetQueueDepths(&QueueDepths{Pending: 4, Processing: 1, Dead: 2, WorkersIdle: 3, WorkersRunning: 5})
+	got := collectQueueDepths(t, reader)
+	if got["pending"] != 4 || got["processing"] != 1 || got["dead"] != 2 || len(got) != 3 {
+		t.Errorf("gauge reported %v", got)
+	}
+	workers := collectGauge(t, reader, "sim.workers.live", "status")
+	if workers["idle"] != 3 || workers["running"] != 5 || len(workers) != 2 {
+		t.Errorf("worker gauge reported %v", workers)
+	}
+
+	p.SetQueueDepths(nil)
+	if got := collectQueueDepths(t, reader); len(got) != 0 {
+		t.Errorf("stale gauge reported %v", got)
+	}
+	if got := collectGauge(t, reader, "sim.workers.live", "status"); len(got) != 0 {
+		t.Errorf("stale worker gauge reported %v", got)
+	}
+}
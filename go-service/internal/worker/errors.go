@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Error codes stored in Result.ErrorCode. They are part of the result format
+// and must not be renamed; clients branch on them instead of ErrorMessage.
+const (
+	CodeInvalidJob         = "INVALID_JOB"          // payload is not a valid job object
+	CodeMetadataTooLarge   = "METADATA_TOO_LARGE"   // metadata exceeds maxMetadataBytes
+	CodeTooManyTags        = "TOO_MANY_TAGS"        // more than maxTags tags
+	CodeInvalidTag         = "INVALID_TAG"          // tag is empty, too long or has disallowed characters
+	CodeInvalidSimConfig   = "INVALID_SIM_CONFIG"   // sim_config has unknown fields or values
+	CodeInvalidTimeout     = "INVALID_TIMEOUT"      // timeout_seconds outside the allowed range
+	CodeInvalidBase64      = "INVALID_BASE64"       // binary_b64 is not valid base64
+	CodeBinaryTooLarge     = "BINARY_TOO_LARGE"     // decoded binary exceeds MaxBinaryBytes
+	CodeBinaryNotAllowed   = "BINARY_NOT_ALLOWED"   // binary sha256 is not on the allow-list
+	CodeInvalidImage       = "INVALID_IMAGE"        // binary is not a bootable Cortex-M flash image
+	CodeBinaryNotFound     = "BINARY_NOT_FOUND"     // binary_sha256 is not in the blob store
+	CodeBinaryHashMismatch = "BINARY_HASH_MISMATCH" // stored blob does not hash to binary_sha256
+	CodeBlobStoreDisabled  = "BLOB_STORE_DISABLED"  // binary_sha256 given without a blob store
+	CodeInternal           = "INTERNAL_ERROR"       // worker-side failure (temp files, I/O)
+	CodeSimTimeout         = "SIM_TIMEOUT"          // simulator exceeded its wall-clock timeout
+	CodeSimCrash           = "SIM_CRASH"            // simulator exited with a non-zero code
+	CodeSimError           = "SIM_ERROR"            // simulator could not be run or its output parsed
+	CodeJobAbandoned       = "JOB_ABANDONED"        // job dead-lettered after repeated worker loss
+)
+
+// jobError is a job failure carrying its error code.
+type jobError struct {
+	code string
+	msg  string
+}
+
+func (e *jobError) Error() string { return e.msg }
+
+func newJobError(code, format string, args ...any) *jobError {
+	return &jobError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the code attached to err, or CodeInternal.
+func errorCode(err error) string {
+	var je *jobError
+	if errors.As(err, &je) {
+		return je.code
+	}
+	return CodeInternal
+}
+
+// simErrorCode maps a non-ok simulator status to its error code.
+func simErrorCode(status string) string {
+	switch status {
+	case "ok":
+		return ""
+	case "timeout":
+		return CodeSimTimeout
+	case "crash":
+		return CodeSimCrash
+	default:
+		return CodeSimError
+	}
+}
+
+// describeDecodeError turns a json.Unmarshal failure on a job payload into
+// an actionable message.
+func describeDecodeError(err error, raw []byte) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case len(bytes.TrimSpace(raw)) == 0:
+		return "job payload is empty"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at offset %d: %v", syntaxErr.Offset, syntaxErr)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("field %s must be %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind()), typeErr.Value)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("job payload must be a JSON object, got %s", typeErr.Value)
+	default:
+		return err.Error()
+	}
+}
+
+func jsonTypeName(k reflect.Kind) string {
+	switch k {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return k.String()
+	}
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDescribeDecodeError(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"empty", "", "job payload is empty"},
+		{"whitespace", "  \n", "job payload is empty"},
+		{"syntax", `{"id": "j1",}`, "malformed JSON at offset 13"},
+		{"truncated", `{"id": "j1"`, "unexpected end of JSON input"},
+		{"string field", `{"id": 42}`, "field id must be a string, got number"},
+		{"bool field", `{"id":"j1","skip_image_check":"yes"}`, "field skip_image_check must be a boolean, got string"},
+		{"number field", `{"id":"j1","timeout_seconds":"30"}`, "field timeout_seconds must be a number, got string"},
+		{"array field", `{"id":"j1","tags":"lab1"}`, "field tags must be an array, got string"},
+		{"array payload", `["j1"]`, "job payload must be a JSON object, got array"},
+		{"string payload", `"j1"`, "job payload must be a JSON object, got string"},
+	} {
+		var job Job
+		err := json.Unmarshal([]byte(tc.raw), &job)
+		if err == nil {
+			t.Fatalf("%s: payload %q decoded without error", tc.name, tc.raw)
+		}
+		if got := describeDecodeError(err, []byte(tc.raw)); !strings.Contains(got, tc.want) {
+			t.Errorf("%s: describeDecodeError = %q, want it to contain %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"stm32sim-service/internal/blob"
+	"stm32sim-service/internal/telemetry"
+)
+
+// fakeQueue is an in-memory jobQueue that records what a worker stores.
+type fakeQueue struct {
+	mu      sync.Mutex
+	results map[string]Result
+	tags    map[string][]string
+	acked   [][]byte
+	stats   map[string]int64
+	// dead is returned by the next RequeueStale call.
+	dead [][]byte
+	// pending is handed out by Dequeue before it blocks.
+	pending [][]byte
+	// versions records the emulator version of each status update.
+	versions []string
+}
+
+func newFakeQueue() *fakeQueue {
+	return &fakeQueue{
+		results: map[string]Result{},
+		tags:    map[string][]string{},
+		stats:   map[string]int64{},
+	}
+}
+
+// Dequeue, like the real queue, only notices cancellation between bounded
+// waits: go-redis cannot interrupt a blocking read.
+func (f *fakeQueue) Dequeue(ctx context.Context, workerID string) ([]byte, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		f.mu.Lock()
+		if len(f.pending) > 0 {
+			raw := f.pending[0]
+			f.pending = f.pending[1:]
+			f.mu.Unlock()
+			return raw, nil
+		}
+		f.mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// AckDone and StoreResult fail on a cancelled ctx, as go-redis does.
+func (f *fakeQueue) AckDone(ctx context.Context, raw []byte) {
+	if ctx.Err() != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acked = append(f.acked, raw)
+}
+
+// StoreResult round-trips result through JSON, as the real queue does.
+func (f *fakeQueue) StoreResult(ctx context.Context, jobID string, result any, tags ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	var res Result
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[jobID] = res
+	f.tags[jobID] = tags
+	return nil
+}
+
+func (f *fakeQueue) RequeueStale(ctx context.Context, olderThan time.Duration, maxAttempts int) ([][]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dead := f.dead
+	f.dead = nil
+	return dead, nil
+}
+
+func (f *fakeQueue) IncrStats(ctx context.Context, field string, by int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats[field] += by
+}
+
+func (f *fakeQueue) UpdateWorkerStatus(ctx context.Context, workerID, status, version string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.versions = append(f.versions, version)
+}
+
+func (f *fakeQueue) result(t *testing.T, jobID string) Result {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res, ok := f.results[jobID]
+	if !ok {
+		t.Fatalf("no result stored for %s (have %d results)", jobID, len(f.results))
+	}
+	return res
+}
+
+func noopTelemetry(t *testing.T) *telemetry.Provider {
+	t.Helper()
+	tel := &telemetry.Provider{}
+	if err := telemetry.InitNoOp(tel); err != nil {
+		t.Fatal(err)
+	}
+	return tel
+}
+
+// fakeSimulator writes a shell script that stands in for stm32sim.
+func fakeSimulator(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stm32sim")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fakeBlobs is an in-memory blob.Store.
+type fakeBlobs struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+	puts  int
+}
+
+func (f *fakeBlobs) Put(ctx context.Context, sha256 string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.blobs == nil {
+		f.blobs = map[string][]byte{}
+	}
+	f.blobs[sha256] = data
+	f.puts++
+	return nil
+}
+
+func (f *fakeBlobs) Get(ctx context.Context, sha256 string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.blobs[sha256]
+	if !ok {
+		return nil, blob.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *fakeBlobs) Exists(ctx context.Context, sha256 string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.blobs[sha256]
+	return ok, nil
+}
@@ -2,8 +2,13 @@ package worker
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
+	"os"
 	"sync"
+	"time"
 
 	"stm32sim-service/internal/queue"
 	"stm32sim-service/internal/simulator"
@@ -11,32 +16,77 @@ import (
 )
 
 type Pool struct {
-	workers []*Worker
-	wg      sync.WaitGroup
-	cancel  context.CancelFunc
+	instance  string
+	workers   []*Worker
+	wg        sync.WaitGroup
+	cancel    context.CancelFunc
+	cancelJob context.CancelFunc
 }
 
-func NewPool(n int, q *queue.Queue, simCfg simulator.Config, tel *telemetry.Provider) *Pool {
-	p := &Pool{}
+// NewPool creates n workers whose IDs are prefixed with a fresh instance ID.
+// Job ownership is decided by worker heartbeats, so IDs must never be reused
+// by another process: a restarted container would otherwise keep its dead
+// predecessor's jobs looking owned by a live worker.
+func NewPool(n int, q *queue.Queue, cfg Config, simCfg simulator.Config, tel *telemetry.Provider) *Pool {
+	p := &Pool{instance: instanceID()}
 	for i := 0; i < n; i++ {
-		id := fmt.Sprintf("worker-%d", i+1)
-		p.workers = append(p.workers, New(id, q, simCfg, tel))
+		id := fmt.Sprintf("%s-worker-%d", p.instance, i+1)
+		p.workers = append(p.workers, New(id, q, cfg, simCfg, tel))
 	}
 	return p
 }
 
+// Instance returns the ID prefix shared by this pool's workers.
+func (p *Pool) Instance() string {
+	return p.instance
+}
+
+// instanceID returns the hostname plus a random suffix. The hostname alone
+// is not enough: a restarted container keeps it, and usually its PID too.
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "sim"
+	}
+	var b [4]byte
+	rand.Read(b[:])
+	return host + "-" + hex.EncodeToString(b[:])
+}
+
+// Start runs every worker until ctx is cancelled. In-flight jobs are not
+// tied to ctx; they keep running until Shutdown's grace period ends.
 func (p *Pool) Start(ctx context.Context) {
+	jobCtx, cancelJob := context.WithCancel(context.WithoutCancel(ctx))
 	ctx, p.cancel = context.WithCancel(ctx)
+	p.cancelJob = cancelJob
 	for _, w := range p.workers {
 		p.wg.Add(1)
 		go func(w *Worker) {
 			defer p.wg.Done()
-			w.Run(ctx)
+			w.Run(ctx, jobCtx)
 		}(w)
 	}
 }
 
-func (p *Pool) Shutdown() {
+// Shutdown stops workers from taking new jobs and waits up to grace for
+// in-flight jobs to finish. Idle workers stop within one Dequeue wait. Jobs still running after that are killed; their
+// results are not stored, so they stay in the processing list. Worker IDs
+// are never reused (see NewPool), so the reaper of any surviving or
+// restarted instance requeues them once the workers' heartbeats expire,
+// within queue.HeartbeatTTL plus one reaper interval.
+func (p *Pool) Shutdown(grace time.Duration) {
 	p.cancel()
-	p.wg.Wait()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		slog.Warn("shutdown grace period elapsed, killing in-flight jobs")
+		p.cancelJob()
+		<-done
+	}
+	p.cancelJob()
 }
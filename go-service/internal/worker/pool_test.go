@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"stm32sim-service/internal/simulator"
+)
+
+func TestPoolWorkerIDsAreUniquePerInstance(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		p := NewPool(3, nil, Config{}, simulator.Config{}, nil)
+		for _, w := range p.workers {
+			if !strings.HasPrefix(w.id, p.Instance()+"-worker-") {
+				t.Errorf("worker ID %q lacks instance prefix %q", w.id, p.Instance())
+			}
+			if seen[w.id] {
+				t.Errorf("worker ID %q reused by a second pool", w.id)
+			}
+			seen[w.id] = true
+		}
+	}
+}
+
+// startPool runs one worker on q with a fake simulator that runs script and
+// returns once the worker has picked up its job.
+func startPool(t *testing.T, q *fakeQueue, script string) *Pool {
+	t.Helper()
+	simCfg := simulator.Config{BinaryPath: fakeSimulator(t, script), Timeout: time.Minute}
+	w := newWorker("w1", q, Config{}, simCfg, noopTelemetry(t))
+	p := &Pool{workers: []*Worker{w}}
+	p.Start(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.status.Load() != "running" {
+		if time.Now().After(deadline) {
+			t.Fatal("worker never started the job")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return p
+}
+
+func TestShutdownLetsJobsFinishWithinGrace(t *testing.T) {
+	q := newFakeQueue()
+	q.pending = [][]byte{[]byte(`{"id":"j1"}`)}
+	p := startPool(t, q, `sleep 0.3; echo '{"halt_reason":"bkpt"}'`)
+
+	p.Shutdown(10 * time.Second)
+
+	if res := q.result(t, "j1"); res.Status != "ok" {
+		t.Errorf("status = %s (%s), want ok", res.Status, res.ErrorMessage)
+	}
+	if len(q.acked) != 1 {
+		t.Errorf("acked %d jobs, want 1", len(q.acked))
+	}
+}
+
+func TestShutdownKillsJobsAfterGrace(t *testing.T) {
+	q := newFakeQueue()
+	q.pending = [][]byte{[]byte(`{"id":"j1"}`)}
+	p := startPool(t, q, `exec sleep 30`)
+
+	start := time.Now()
+	p.Shutdown(100 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Shutdown took %v", elapsed)
+	}
+
+	// The killed job is neither stored nor acked, so it stays in processing
+	// for the reaper.
+	if len(q.results) != 0 || len(q.acked) != 0 {
+		t.Errorf("killed job left %d results and %d acks", len(q.results), len(q.acked))
+	}
+}
+
+func TestShutdownIdlePool(t *testing.T) {
+	w := newWorker("w1", newFakeQueue(), Config{}, simulator.Config{}, noopTelemetry(t))
+	p := &Pool{workers: []*Worker{w}}
+	p.Start(context.Background())
+	time.Sleep(100 * time.Millisecond) // let the worker block in Dequeue
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Shutdown(time.Minute)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown of an idle pool did not return")
+	}
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"stm32sim-service/internal/queue"
+)
+
+// ReaperConfig controls how abandoned jobs are detected and retried.
+type ReaperConfig struct {
+	// Interval between scans of the processing list.
+	Interval time.Duration
+	// StaleAfter is how long a job may stay claimed before it is considered
+	// abandoned even though its worker still heartbeats. It must comfortably
+	// exceed the simulator timeout.
+	StaleAfter time.Duration
+	// MaxAttempts is the number of requeues after which a job is moved to
+	// the dead-letter list and failed.
+	MaxAttempts int
+	// EmulatorVersion is recorded on failure results.
+	EmulatorVersion string
+}
+
+// staleQueue is the part of *queue.Queue a Reaper uses.
+type staleQueue interface {
+	RequeueStale(ctx context.Context, olderThan time.Duration, maxAttempts int) ([][]byte, error)
+	StoreResult(ctx context.Context, jobID string, result any, tags ...string) error
+	IncrStats(ctx context.Context, field string, by int64)
+}
+
+// Reaper returns jobs left in sim:jobs:processing by crashed workers to the
+// pending list, and dead-letters jobs that keep getting abandoned. A job is
+// abandoned once its worker's heartbeat expires, or after StaleAfter
+// regardless of heartbeat.
+type Reaper struct {
+	q   staleQueue
+	cfg ReaperConfig
+}
+
+func NewReaper(q *queue.Queue, cfg ReaperConfig) *Reaper {
+	return &Reaper{q: q, cfg: cfg}
+}
+
+// Run blocks, scanning every Interval until ctx is cancelled.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.scan(ctx)
+		}
+	}
+}
+
+func (r *Reaper) scan(ctx context.Context) {
+	dead, err := r.q.RequeueStale(ctx, r.cfg.StaleAfter, r.cfg.MaxAttempts)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("requeue of stale jobs failed", "err", err)
+	}
+	for _, raw := range dead {
+		var job Job
+		if err := json.Unmarshal(raw, &job); err != nil || job.ID == "" {
+			slog.Error("dead-lettered unparseable job", "err", err)
+			continue
+		}
+		result := Result{
+			JobID:           job.ID,
+			Status:          "error",
+			ErrorCode:       CodeJobAbandoned,
+			CompletedAt:     time.Now(),
+			ErrorMessage:    fmt.Sprintf("worker lost %d times; moved to dead-letter queue", job.Attempts),
+			EmulatorVersion: r.cfg.EmulatorVersion,
+			Metadata:        normalizeMetadata(job.ID, job.Metadata),
+			Tags:            job.Tags,
+		}
+		if err := r.q.StoreResult(ctx, job.ID, result, job.Tags...); err != nil {
+			slog.Error("failed to store dead-letter result", "job", job.ID, "err", err)
+		}
+		r.q.IncrStats(ctx, "jobs_total", 1)
+		r.q.IncrStats(ctx, "jobs_failed", 1)
+		r.q.IncrStats(ctx, "jobs_dead", 1)
+		slog.Warn("job dead-lettered", "job", job.ID, "attempts", job.Attempts)
+	}
+}
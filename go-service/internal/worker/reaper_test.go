@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestReaperFailsDeadLetteredJobs(t *testing.T) {
+	q := newFakeQueue()
+	q.dead = [][]byte{
+		[]byte(`{"id":"job-1","attempts":3,"tags":["lab1"],"metadata":{"student":"s1"}}`),
+		[]byte(`not json`),
+	}
+	r := &Reaper{q: q, cfg: ReaperConfig{MaxAttempts: 3, EmulatorVersion: "v1"}}
+
+	r.scan(context.Background())
+
+	res := q.result(t, "job-1")
+	if res.Status != "error" || res.ErrorCode != CodeJobAbandoned {
+		t.Errorf("result = {Status:%s ErrorCode:%s}, want error/%s", res.Status, res.ErrorCode, CodeJobAbandoned)
+	}
+	if want := "worker lost 3 times; moved to dead-letter queue"; res.ErrorMessage != want {
+		t.Errorf("ErrorMessage = %q, want %q", res.ErrorMessage, want)
+	}
+	if res.EmulatorVersion != "v1" || string(res.Metadata) != `{"student":"s1"}` {
+		t.Errorf("result lost job details: %+v", res)
+	}
+	if !slices.Equal(q.tags["job-1"], []string{"lab1"}) {
+		t.Errorf("result indexed under tags %v", q.tags["job-1"])
+	}
+	if len(q.results) != 1 {
+		t.Errorf("stored %d results; the unparseable payload must be skipped", len(q.results))
+	}
+	if q.stats["jobs_dead"] != 1 || q.stats["jobs_failed"] != 1 {
+		t.Errorf("stats = %v", q.stats)
+	}
+}
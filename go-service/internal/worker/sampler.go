@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"stm32sim-service/internal/queue"
+	"stm32sim-service/internal/telemetry"
+)
+
+// depthSource is the part of *queue.Queue a DepthSampler uses.
+type depthSource interface {
+	Depths(ctx context.Context) (pending, processing, dead int64, err error)
+	Workers(ctx context.Context) (idle, running int64, err error)
+}
+
+// DepthSampler periodically reads the queue depths and live worker counts
+// into the telemetry provider, so metric collection serves a cached value
+// instead of issuing LLEN and SCAN on every export.
+type DepthSampler struct {
+	q        depthSource
+	tel      *telemetry.Provider
+	interval time.Duration
+}
+
+func NewDepthSampler(q *queue.Queue, tel *telemetry.Provider, interval time.Duration) *DepthSampler {
+	return &DepthSampler{q: q, tel: tel, interval: interval}
+}
+
+// Run blocks, sampling every interval until ctx is cancelled.
+func (s *DepthSampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.sample(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *DepthSampler) sample(ctx context.Context) {
+	pending, processing, dead, err := s.q.Depths(ctx)
+	var idle, running int64
+	if err == nil {
+		idle, running, err = s.q.Workers(ctx)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("queue depth sample failed, marking gauge stale", "err", err)
+		}
+		s.tel.SetQueueDepths(nil)
+		return
+	}
+	s.tel.SetQueueDepths(&telemetry.QueueDepths{
+		Pending:        pending,
+		Processing:     processing,
+		Dead:           dead,
+		WorkersIdle:    idle,
+		WorkersRunning: running,
+	})
+}
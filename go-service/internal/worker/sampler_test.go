@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"stm32sim-service/internal/telemetry"
+)
+
+type fakeDepths struct {
+	pending, processing, dead int64
+	idle, running             int64
+	err, workersErr           error
+	calls                     int
+}
+
+func (f *fakeDepths) Depths(ctx context.Context) (int64, int64, int64, error) {
+	f.calls++
+	return f.pending, f.processing, f.dead, f.err
+}
+
+func (f *fakeDepths) Workers(ctx context.Context) (int64, int64, error) {
+	return f.idle, f.running, f.workersErr
+}
+
+func TestDepthSamplerUpdatesCachedGauge(t *testing.T) {
+	src := &fakeDepths{pending: 5, processing: 2, dead: 1, idle: 3, running: 4}
+	tel := &telemetry.Provider{}
+	s := &DepthSampler{q: src, tel: tel}
+
+	s.sample(context.Background())
+	want := telemetry.QueueDepths{Pending: 5, Processing: 2, Dead: 1, WorkersIdle: 3, WorkersRunning: 4}
+	if got := tel.QueueDepths(); got == nil || *got != want {
+		t.Fatalf("cached depths = %+v, want %+v", got, want)
+	}
+
+	// Reading the cache does not query the queue again.
+	tel.QueueDepths()
+	if src.calls != 1 {
+		t.Errorf("queue queried %d times, want 1", src.calls)
+	}
+
+	src.err = errors.New("connection refused")
+	s.sample(context.Background())
+	if got := tel.QueueDepths(); got != nil {
+		t.Errorf("failed sample left depths %+v; want stale (nil)", got)
+	}
+
+	src.err = nil
+	src.workersErr = errors.New("scan failed")
+	s.sample(context.Background())
+	if got := tel.QueueDepths(); got != nil {
+		t.Errorf("failed worker count left depths %+v; want stale (nil)", got)
+	}
+
+	src.workersErr = nil
+	src.pending = 7
+	s.sample(context.Background())
+	if got := tel.QueueDepths(); got == nil || got.Pending != 7 {
+		t.Errorf("recovered sample = %+v", got)
+	}
+}
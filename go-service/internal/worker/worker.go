@@ -1,18 +1,28 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log/slog"
+	"math"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 
+	"stm32sim-service/internal/blob"
 	"stm32sim-service/internal/queue"
 	"stm32sim-service/internal/simulator"
 	"stm32sim-service/internal/telemetry"
@@ -20,37 +30,200 @@ import (
 
 var tracer = otel.Tracer("worker")
 
-// Job is the incoming task from KeyDB.
+// Bounds for the delay between retries when Dequeue fails; variables so
+// tests can shorten them.
+var (
+	dequeueBackoffMin = time.Second
+	dequeueBackoffMax = 30 * time.Second
+)
+
+// Limits on client-supplied job annotations; they are echoed into the
+// stored result, so they must stay small.
+const (
+	maxMetadataBytes = 4096
+	maxTags          = 32
+	maxTagLen        = 64
+)
+
+// Job is the incoming task from KeyDB. The firmware is either inline in
+// BinaryB64 or, when a blob store is configured, referenced by BinarySHA256.
 type Job struct {
-	ID          string `json:"id"`
-	BinaryB64   string `json:"binary_b64"`
-	SubmittedAt string `json:"submitted_at,omitempty"`
+	ID           string          `json:"id"`
+	BinaryB64    string          `json:"binary_b64,omitempty"`
+	BinarySHA256 string          `json:"binary_sha256,omitempty"`
+	SubmittedAt  string          `json:"submitted_at,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	Tags         []string        `json:"tags,omitempty"`
+	// TimeoutSeconds overrides the simulator wall-clock timeout for this job;
+	// it must lie within Config.MinTimeout..Config.MaxTimeout.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// SkipImageCheck disables the pre-flight image validation for custom
+	// payloads the check would reject.
+	SkipImageCheck bool `json:"skip_image_check,omitempty"`
+	// SimConfig optionally tunes the simulator for this job; see SimConfig.
+	SimConfig json.RawMessage `json:"sim_config,omitempty"`
+	// Attempts counts how many times the job was requeued after its worker
+	// abandoned it; see Reaper.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// SimConfig is the per-job simulator configuration. Only settings the
+// simulator binary actually supports are accepted; unknown fields are
+// rejected rather than silently ignored. The effective configuration is
+// recorded on the Result.
+type SimConfig struct {
+	// Board selects the emulated board. The simulator has a single memory
+	// map, so any supported name resolves to the STM32F103C8.
+	Board string `json:"board,omitempty"`
+	// MaxCycles lowers the worker's cycle budget for this job. It cannot
+	// raise it above the configured SIM_MAX_CYCLES.
+	MaxCycles uint64 `json:"max_cycles,omitempty"`
+}
+
+// supportedBoards maps accepted board names to the model the simulator
+// emulates.
+var supportedBoards = map[string]string{
+	"stm32f103c8": "stm32f103c8",
+	"bluepill":    "stm32f103c8",
 }
 
+const defaultBoard = "stm32f103c8"
+
 // Result is the full output stored in KeyDB.
 type Result struct {
-	JobID          string            `json:"job_id"`
-	Status         string            `json:"status"`
-	CompletedAt    time.Time         `json:"completed_at"`
-	WallDurationMs int64             `json:"wall_duration_ms"`
-	Sim            simulator.SimOutput `json:"sim"`
-	ErrorMessage   string            `json:"error_message,omitempty"`
+	JobID           string              `json:"job_id"`
+	Status          string              `json:"status"`
+	BinarySHA256    string              `json:"binary_sha256,omitempty"`
+	EmulatorVersion string              `json:"emulator_version,omitempty"`
+	CompletedAt     time.Time           `json:"completed_at"`
+	WallDurationMs  int64               `json:"wall_duration_ms"`
+	Sim             simulator.SimOutput `json:"sim"`
+	SimConfig       *SimConfig          `json:"sim_config,omitempty"` // effective settings the job ran with
+	ErrorCode       string              `json:"error_code,omitempty"`
+	ErrorMessage    string              `json:"error_message,omitempty"`
+	Metadata        json.RawMessage     `json:"metadata,omitempty"`
+	Tags            []string            `json:"tags,omitempty"`
+}
+
+// IDGenerator produces IDs for jobs that arrive without one.
+type IDGenerator interface {
+	NewID() string
+}
+
+// IDGeneratorFunc adapts a plain function to IDGenerator, e.g. to supply
+// deterministic IDs in tests.
+type IDGeneratorFunc func() string
+
+func (f IDGeneratorFunc) NewID() string { return f() }
+
+// timestampIDs is the default generator: "auto-" plus the current Unix nanos.
+type timestampIDs struct{}
+
+func (timestampIDs) NewID() string {
+	return fmt.Sprintf("auto-%d", time.Now().UnixNano())
+}
+
+// Config holds worker behaviour that is not specific to the simulator.
+type Config struct {
+	// LogSampleRate is the fraction (0.0–1.0) of successful, fast jobs whose
+	// completion is logged. Failed and slow jobs are always logged.
+	LogSampleRate float64
+	// SlowJobThreshold marks a job as slow for logging purposes.
+	SlowJobThreshold time.Duration
+	// MinTimeout and MaxTimeout bound a job's timeout_seconds. Jobs without
+	// one use the simulator config's Timeout.
+	MinTimeout time.Duration
+	MaxTimeout time.Duration
+	// ValidateImages rejects binaries that are not bootable Cortex-M images
+	// before they reach the simulator; jobs can opt out per job.
+	ValidateImages bool
+	// AllowedSHA256, when non-empty, restricts jobs to binaries whose
+	// lowercase hex sha256 is in the set.
+	AllowedSHA256 map[string]bool
+	// MaxBinaryBytes caps the decoded firmware size. Oversized jobs are
+	// rejected before the binary is decoded or fetched into memory. Zero
+	// means no limit.
+	MaxBinaryBytes int
+	// Blobs, when set, stores every inline binary by sha256 and resolves
+	// jobs that reference a binary by hash only.
+	Blobs blob.Store
+	// IDs generates IDs for jobs submitted without one; defaults to
+	// timestamp-based "auto-…" IDs when nil.
+	IDs IDGenerator
+}
+
+// jobQueue is the part of *queue.Queue a Worker uses.
+type jobQueue interface {
+	Dequeue(ctx context.Context, workerID string) ([]byte, error)
+	AckDone(ctx context.Context, raw []byte)
+	StoreResult(ctx context.Context, jobID string, result any, tags ...string) error
+	IncrStats(ctx context.Context, field string, by int64)
+	UpdateWorkerStatus(ctx context.Context, workerID, status, version string)
 }
 
 type Worker struct {
 	id     string
-	q      *queue.Queue
+	q      jobQueue
+	cfg    Config
 	simCfg simulator.Config
 	tel    *telemetry.Provider
+	status atomic.Value // string: "idle" or "running"
 }
 
-func New(id string, q *queue.Queue, simCfg simulator.Config, tel *telemetry.Provider) *Worker {
-	return &Worker{id: id, q: q, simCfg: simCfg, tel: tel}
+func New(id string, q *queue.Queue, cfg Config, simCfg simulator.Config, tel *telemetry.Provider) *Worker {
+	return newWorker(id, q, cfg, simCfg, tel)
+}
+
+func newWorker(id string, q jobQueue, cfg Config, simCfg simulator.Config, tel *telemetry.Provider) *Worker {
+	if cfg.IDs == nil {
+		cfg.IDs = timestampIDs{}
+	}
+	w := &Worker{id: id, q: q, cfg: cfg, simCfg: simCfg, tel: tel}
+	w.status.Store("idle")
+	return w
+}
+
+// setStatus records the worker's status and publishes it immediately.
+func (w *Worker) setStatus(ctx context.Context, status string) {
+	w.status.Store(status)
+	w.q.UpdateWorkerStatus(ctx, w.id, status, w.simCfg.Version)
+}
+
+// heartbeat republishes the current status well within the heartbeat TTL,
+// including while the worker is blocked in Dequeue or running a long job,
+// so the reaper only sees the key expire once the worker is actually gone.
+func (w *Worker) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(queue.HeartbeatTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.q.UpdateWorkerStatus(ctx, w.id, w.status.Load().(string), w.simCfg.Version)
+		}
+	}
 }
 
 // Run blocks, continuously pulling jobs from the queue until ctx is cancelled.
-func (w *Worker) Run(ctx context.Context) {
+// Jobs run under jobCtx instead, so a job already taken off the queue can
+// finish after ctx is cancelled. The heartbeat stops when Run returns.
+func (w *Worker) Run(ctx, jobCtx context.Context) {
 	slog.Info("worker started", "id", w.id)
+	w.setStatus(jobCtx, "idle")
+
+	hbCtx, stopHeartbeat := context.WithCancel(jobCtx)
+	hbDone := make(chan struct{})
+	go func() {
+		defer close(hbDone)
+		w.heartbeat(hbCtx)
+	}()
+	defer func() {
+		stopHeartbeat()
+		<-hbDone
+	}()
+
+	backoff := dequeueBackoffMin
 	for {
 		select {
 		case <-ctx.Done():
@@ -59,17 +232,28 @@ func (w *Worker) Run(ctx context.Context) {
 		default:
 		}
 
-		raw, err := w.q.Dequeue(ctx)
+		raw, err := w.q.Dequeue(ctx, w.id)
 		if err != nil {
 			if ctx.Err() != nil {
 				return
 			}
-			slog.Error("dequeue error", "worker", w.id, "err", err)
-			time.Sleep(time.Second)
+			// KeyDB is likely down or restarting: go-redis reconnects on the
+			// next command, so back off to avoid hammering it meanwhile.
+			slog.Error("dequeue error, retrying", "worker", w.id, "err", err, "retry_in", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, dequeueBackoffMax)
 			continue
 		}
+		if backoff != dequeueBackoffMin {
+			slog.Info("dequeue recovered", "worker", w.id)
+			backoff = dequeueBackoffMin
+		}
 
-		w.process(ctx, raw)
+		w.process(jobCtx, raw)
 	}
 }
 
@@ -77,42 +261,88 @@ func (w *Worker) process(ctx context.Context, raw []byte) {
 	ctx, span := tracer.Start(ctx, "job.process")
 	defer span.End()
 
-	w.q.UpdateWorkerStatus(ctx, w.id, "running")
+	w.setStatus(ctx, "running")
 	w.tel.ActiveWorkers.Add(ctx, 1)
 	defer func() {
-		w.q.UpdateWorkerStatus(ctx, w.id, "idle")
+		w.setStatus(ctx, "idle")
 		w.tel.ActiveWorkers.Add(ctx, -1)
 	}()
 
 	// Parse job
 	var job Job
 	if err := json.Unmarshal(raw, &job); err != nil {
-		slog.Error("failed to parse job", "worker", w.id, "err", err)
+		msg := describeDecodeError(err, raw)
+		slog.Error("failed to parse job", "worker", w.id, "err", msg)
+		// A type error still decodes the other fields, so the submitter can
+		// be told what was wrong if the job ID came through.
+		if job.ID != "" {
+			w.storeError(ctx, job.ID, CodeInvalidJob, msg, raw)
+			return
+		}
 		w.q.AckDone(ctx, raw)
 		return
 	}
 	if job.ID == "" {
-		job.ID = fmt.Sprintf("auto-%d", time.Now().UnixNano())
+		job.ID = w.cfg.IDs.NewID()
 	}
 
 	span.SetAttributes(attribute.String("job.id", job.ID))
 
+	job.Metadata = normalizeMetadata(job.ID, job.Metadata)
+
+	if len(job.Metadata) > maxMetadataBytes {
+		slog.Error("job metadata too large", "job", job.ID, "bytes", len(job.Metadata))
+		w.storeError(ctx, job.ID, CodeMetadataTooLarge, fmt.Sprintf("metadata exceeds %d bytes", maxMetadataBytes), raw)
+		return
+	}
+	if len(job.Tags) > maxTags {
+		slog.Error("too many job tags", "job", job.ID, "tags", len(job.Tags))
+		w.storeError(ctx, job.ID, CodeTooManyTags, fmt.Sprintf("at most %d tags are allowed", maxTags), raw)
+		return
+	}
+	for _, tag := range job.Tags {
+		if !validTag(tag) {
+			slog.Error("invalid job tag", "job", job.ID, "tag", tag)
+			w.storeError(ctx, job.ID, CodeInvalidTag,
+				fmt.Sprintf("tag %.64q must be 1-%d letters, digits, '.', '_', ':' or '-'", tag, maxTagLen), raw)
+			return
+		}
+	}
+
+	simCfg, effective, err := w.simConfigFor(job)
+	if err != nil {
+		slog.Error("invalid sim config", "job", job.ID, "err", err)
+		w.storeError(ctx, job.ID, errorCode(err), err.Error(), raw)
+		return
+	}
+
 	// Decode binary
-	_, decodeSpan := tracer.Start(ctx, "job.dequeue")
+	decodeCtx, decodeSpan := tracer.Start(ctx, "job.dequeue")
 	decodeSpan.SetAttributes(attribute.String("job.id", job.ID))
-	firmware, err := base64.StdEncoding.DecodeString(job.BinaryB64)
+	firmware, binarySHA, err := w.loadFirmware(decodeCtx, job)
 	decodeSpan.End()
 	if err != nil {
-		slog.Error("base64 decode failed", "job", job.ID, "err", err)
-		w.storeError(ctx, job.ID, "error", "invalid base64 binary", raw)
+		slog.Error("failed to load binary", "job", job.ID, "err", err)
+		w.storeError(ctx, job.ID, errorCode(err), err.Error(), raw)
 		return
 	}
 
+	if err := w.admitFirmware(job, firmware, binarySHA); err != nil {
+		slog.Warn("firmware rejected", "job", job.ID, "sha256", binarySHA, "err", err)
+		w.storeError(ctx, job.ID, errorCode(err), err.Error(), raw)
+		return
+	}
+	// Only admitted binaries are persisted, so a locked-down deployment's
+	// blob store never fills with rejected uploads.
+	if !job.byHash() && w.cfg.Blobs != nil {
+		w.saveBlob(ctx, job.ID, binarySHA, firmware)
+	}
+
 	// Write firmware to temp file
 	tmpPath, err := simulator.WriteTempFile(firmware)
 	if err != nil {
 		slog.Error("temp file creation failed", "job", job.ID, "err", err)
-		w.storeError(ctx, job.ID, "error", err.Error(), raw)
+		w.storeError(ctx, job.ID, CodeInternal, err.Error(), raw)
 		return
 	}
 	defer os.Remove(tmpPath)
@@ -123,7 +353,7 @@ func (w *Worker) process(ctx context.Context, raw []byte) {
 		attribute.String("job.id", job.ID),
 		attribute.Int64("sim.binary_size_bytes", int64(len(firmware))),
 	)
-	runResult := simulator.Run(execCtx, w.simCfg, tmpPath)
+	runResult := simulator.Run(execCtx, simCfg, tmpPath)
 	execSpan.End()
 
 	// Record metrics
@@ -136,12 +366,18 @@ func (w *Worker) process(ctx context.Context, raw []byte) {
 
 	// Build and store result
 	result := Result{
-		JobID:          job.ID,
-		Status:         runResult.Status,
-		CompletedAt:    runResult.CompletedAt,
-		WallDurationMs: runResult.WallDurationMs,
-		Sim:            runResult.Sim,
-		ErrorMessage:   runResult.ErrorMessage,
+		JobID:           job.ID,
+		Status:          runResult.Status,
+		BinarySHA256:    binarySHA,
+		EmulatorVersion: w.simCfg.Version,
+		CompletedAt:     runResult.CompletedAt,
+		WallDurationMs:  runResult.WallDurationMs,
+		Sim:             runResult.Sim,
+		SimConfig:       &effective,
+		ErrorCode:       simErrorCode(runResult.Status),
+		ErrorMessage:    runResult.ErrorMessage,
+		Metadata:        job.Metadata,
+		Tags:            job.Tags,
 	}
 
 	storeCtx, storeSpan := tracer.Start(ctx, "job.result.store")
@@ -149,7 +385,7 @@ func (w *Worker) process(ctx context.Context, raw []byte) {
 		attribute.String("job.id", job.ID),
 		attribute.String("result.status", result.Status),
 	)
-	if err := w.q.StoreResult(storeCtx, job.ID, result); err != nil {
+	if err := w.q.StoreResult(storeCtx, job.ID, result, job.Tags...); err != nil {
 		slog.Error("failed to store result", "job", job.ID, "err", err)
 	}
 	storeSpan.End()
@@ -165,20 +401,200 @@ func (w *Worker) process(ctx context.Context, raw []byte) {
 		w.q.IncrStats(ctx, "cycles_total", int64(result.Sim.Cycles))
 	}
 
-	slog.Info("job completed",
-		"job", job.ID,
-		"status", result.Status,
-		"cycles", result.Sim.Cycles,
-		"duration_ms", result.WallDurationMs,
-	)
+	if w.logged(job.ID, result.Status, time.Duration(result.WallDurationMs)*time.Millisecond) {
+		slog.Info("job completed",
+			"job", job.ID,
+			"status", result.Status,
+			"cycles", result.Sim.Cycles,
+			"duration_ms", result.WallDurationMs,
+		)
+	}
+}
+
+// logged reports whether a finished job's completion is logged: failed and
+// slow jobs always are, the rest only when sampled.
+func (w *Worker) logged(jobID, status string, dur time.Duration) bool {
+	return status != "ok" || dur >= w.cfg.SlowJobThreshold || sampled(jobID, w.cfg.LogSampleRate)
+}
+
+// sampled reports whether a job falls into the logged fraction. The decision
+// is derived from the job ID so it is stable across retries and workers.
+func sampled(jobID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(jobID))
+	return float64(h.Sum32())/float64(math.MaxUint32) < rate
+}
+
+// byHash reports whether the job references its binary by hash only.
+func (j Job) byHash() bool {
+	return j.BinaryB64 == "" && j.BinarySHA256 != ""
+}
+
+// loadFirmware returns the job's binary and its hex sha256. Hash-only jobs
+// are fetched from the blob store.
+func (w *Worker) loadFirmware(ctx context.Context, job Job) ([]byte, string, error) {
+	if job.byHash() {
+		if w.cfg.Blobs == nil {
+			return nil, "", newJobError(CodeBlobStoreDisabled, "binary_sha256 given but no blob store is configured")
+		}
+		rc, err := w.cfg.Blobs.Get(ctx, job.BinarySHA256)
+		if errors.Is(err, blob.ErrNotFound) {
+			return nil, "", newJobError(CodeBinaryNotFound, "binary %s not found", job.BinarySHA256)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("fetch binary %s: %w", job.BinarySHA256, err)
+		}
+		defer rc.Close()
+		var r io.Reader = rc
+		if w.cfg.MaxBinaryBytes > 0 {
+			r = io.LimitReader(rc, int64(w.cfg.MaxBinaryBytes)+1)
+		}
+		firmware, err := io.ReadAll(r)
+		if err != nil {
+			return nil, "", fmt.Errorf("fetch binary %s: %w", job.BinarySHA256, err)
+		}
+		if w.binaryTooLarge(len(firmware)) {
+			return nil, "", w.tooLarge()
+		}
+		sum := sha256.Sum256(firmware)
+		if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, job.BinarySHA256) {
+			return nil, "", newJobError(CodeBinaryHashMismatch, "stored binary hash mismatch: got %s", got)
+		}
+		return firmware, strings.ToLower(job.BinarySHA256), nil
+	}
+
+	// Check the size implied by the encoded length first so an enormous
+	// payload is never decoded.
+	padding := len(job.BinaryB64) - len(strings.TrimRight(job.BinaryB64, "="))
+	if w.binaryTooLarge(base64.StdEncoding.DecodedLen(len(job.BinaryB64)) - padding) {
+		return nil, "", w.tooLarge()
+	}
+	firmware, err := base64.StdEncoding.DecodeString(job.BinaryB64)
+	if err != nil {
+		return nil, "", newJobError(CodeInvalidBase64, "invalid base64 binary")
+	}
+	sum := sha256.Sum256(firmware)
+	return firmware, hex.EncodeToString(sum[:]), nil
+}
+
+// admitFirmware applies the deployment's allow-list and, unless the job opts
+// out, the image check.
+func (w *Worker) admitFirmware(job Job, firmware []byte, binarySHA string) error {
+	if len(w.cfg.AllowedSHA256) > 0 && !w.cfg.AllowedSHA256[binarySHA] {
+		return newJobError(CodeBinaryNotAllowed, "binary %s is not on this deployment's allow-list", binarySHA)
+	}
+	if w.cfg.ValidateImages && !job.SkipImageCheck {
+		if err := simulator.ValidateImage(firmware); err != nil {
+			return newJobError(CodeInvalidImage, "%s", err.Error())
+		}
+	}
+	return nil
+}
+
+// saveBlob stores an inline binary unless the store already has it. Failures
+// are not fatal: the job can still run from the inline copy.
+func (w *Worker) saveBlob(ctx context.Context, jobID, sha string, firmware []byte) {
+	exists, err := w.cfg.Blobs.Exists(ctx, sha)
+	if err == nil && exists {
+		return
+	}
+	if err := w.cfg.Blobs.Put(ctx, sha, firmware); err != nil {
+		slog.Warn("failed to store binary", "job", jobID, "sha256", sha, "err", err)
+	}
+}
+
+// simConfigFor validates the job's timeout_seconds and sim_config and applies
+// them on top of the worker's simulator config. It also returns the
+// effective sim_config to record on the result.
+func (w *Worker) simConfigFor(job Job) (simulator.Config, SimConfig, error) {
+	cfg := w.simCfg
+	if job.TimeoutSeconds != 0 {
+		// Compare in seconds: converting a huge timeout_seconds to a Duration
+		// first would overflow.
+		lo, hi := int64(w.cfg.MinTimeout/time.Second), int64(w.cfg.MaxTimeout/time.Second)
+		if secs := int64(job.TimeoutSeconds); secs < lo || secs > hi {
+			return cfg, SimConfig{}, newJobError(CodeInvalidTimeout, "timeout_seconds must be between %d and %d", lo, hi)
+		}
+		cfg.Timeout = time.Duration(job.TimeoutSeconds) * time.Second
+	}
+	var sc SimConfig
+	if len(job.SimConfig) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(job.SimConfig))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&sc); err != nil {
+			return cfg, SimConfig{}, newJobError(CodeInvalidSimConfig, "invalid sim_config: %v", err)
+		}
+	}
+	board := defaultBoard
+	if sc.Board != "" {
+		var ok bool
+		if board, ok = supportedBoards[strings.ToLower(sc.Board)]; !ok {
+			return cfg, SimConfig{}, newJobError(CodeInvalidSimConfig, "unsupported board %q", sc.Board)
+		}
+	}
+	if sc.MaxCycles > 0 {
+		if cfg.MaxCycles > 0 && sc.MaxCycles > cfg.MaxCycles {
+			return cfg, SimConfig{}, newJobError(CodeInvalidSimConfig, "max_cycles %d exceeds the limit of %d", sc.MaxCycles, cfg.MaxCycles)
+		}
+		cfg.MaxCycles = sc.MaxCycles
+	}
+	return cfg, SimConfig{Board: board, MaxCycles: cfg.MaxCycles}, nil
+}
+
+// validTag reports whether tag is safe to use in a KeyDB key name.
+func validTag(tag string) bool {
+	if tag == "" || len(tag) > maxTagLen {
+		return false
+	}
+	for _, c := range tag {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeMetadata returns metadata if it is a JSON object. Anything else
+// (null, arrays, scalars) is logged and dropped rather than failing the job,
+// since metadata is only an annotation.
+func normalizeMetadata(jobID string, metadata json.RawMessage) json.RawMessage {
+	trimmed := bytes.TrimSpace(metadata)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &obj); err != nil {
+		slog.Warn("ignoring job metadata that is not a JSON object", "job", jobID, "err", err)
+		return nil
+	}
+	return metadata
+}
+
+func (w *Worker) binaryTooLarge(n int) bool {
+	return w.cfg.MaxBinaryBytes > 0 && n > w.cfg.MaxBinaryBytes
+}
+
+func (w *Worker) tooLarge() error {
+	return newJobError(CodeBinaryTooLarge, "binary exceeds the %d byte limit; submit a raw flash image (objcopy -O binary), not an ELF with debug info", w.cfg.MaxBinaryBytes)
 }
 
-func (w *Worker) storeError(ctx context.Context, jobID, status, msg string, raw []byte) {
+func (w *Worker) storeError(ctx context.Context, jobID, code, msg string, raw []byte) {
 	result := Result{
-		JobID:        jobID,
-		Status:       status,
-		CompletedAt:  time.Now(),
-		ErrorMessage: msg,
+		JobID:           jobID,
+		Status:          "error",
+		ErrorCode:       code,
+		CompletedAt:     time.Now(),
+		ErrorMessage:    msg,
+		EmulatorVersion: w.simCfg.Version,
 	}
 	_ = w.q.StoreResult(ctx, jobID, result)
 	w.q.AckDone(ctx, raw)
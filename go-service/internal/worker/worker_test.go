@@ -0,0 +1,488 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stm32sim-service/internal/simulator"
+)
+
+func TestValidTag(t *testing.T) {
+	for _, tc := range []struct {
+		tag  string
+		want bool
+	}{
+		{"lab1", true},
+		{"course:ee101", true},
+		{"v1.2_rc-3", true},
+		{strings.Repeat("a", maxTagLen), true},
+		{"", false},
+		{strings.Repeat("a", maxTagLen+1), false},
+		{"has space", false},
+		{"star*", false},
+		{"sim:jobs:tag:{x}", false},
+		{"ünï", false},
+	} {
+		if got := validTag(tc.tag); got != tc.want {
+			t.Errorf("validTag(%q) = %v, want %v", tc.tag, got, tc.want)
+		}
+	}
+}
+
+func TestSampledRate(t *testing.T) {
+	const n = 10000
+	for _, rate := range []float64{0, 0.1, 0.5, 1} {
+		hits := 0
+		for i := 0; i < n; i++ {
+			if sampled(fmt.Sprintf("job-%d", i), rate) {
+				hits++
+			}
+		}
+		if got := float64(hits) / n; got < rate-0.02 || got > rate+0.02 {
+			t.Errorf("rate %v: sampled %.3f of jobs", rate, got)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		if sampled(id, 0.3) != sampled(id, 0.3) {
+			t.Fatalf("sampling of %s is not deterministic", id)
+		}
+	}
+}
+
+func TestLoggedAlwaysKeepsFailuresAndSlowJobs(t *testing.T) {
+	w := &Worker{cfg: Config{LogSampleRate: 0, SlowJobThreshold: time.Second}}
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		for _, status := range []string{"error", "crash", "timeout"} {
+			if !w.logged(id, status, time.Millisecond) {
+				t.Fatalf("%s job %s was sampled out", status, id)
+			}
+		}
+		if !w.logged(id, "ok", 2*time.Second) {
+			t.Fatalf("slow job %s was sampled out", id)
+		}
+		if w.logged(id, "ok", time.Millisecond) {
+			t.Fatalf("fast ok job %s was logged at sample rate 0", id)
+		}
+	}
+}
+
+func TestLoadFirmwareRejectsOversizedBeforeDecoding(t *testing.T) {
+	w := &Worker{cfg: Config{MaxBinaryBytes: 64 * 1024}}
+	// 32 MiB of characters that are not even valid base64: a decode attempt
+	// would fail with INVALID_BASE64, so BINARY_TOO_LARGE proves the size
+	// check ran first.
+	job := Job{ID: "big", BinaryB64: strings.Repeat("!", 32<<20)}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err := w.loadFirmware(context.Background(), job)
+	runtime.ReadMemStats(&after)
+
+	if code := errorCode(err); code != CodeBinaryTooLarge {
+		t.Fatalf("error code = %s (%v), want %s", code, err, CodeBinaryTooLarge)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("rejection allocated %d bytes", alloc)
+	}
+}
+
+func TestLoadFirmwareSizeLimit(t *testing.T) {
+	firmware := make([]byte, 1024)
+	job := Job{ID: "j", BinaryB64: base64.StdEncoding.EncodeToString(firmware)}
+	for _, tc := range []struct {
+		limit int
+		code  string
+	}{
+		{0, ""}, // no limit
+		{1024, ""},
+		{1023, CodeBinaryTooLarge},
+	} {
+		w := &Worker{cfg: Config{MaxBinaryBytes: tc.limit}}
+		got, _, err := w.loadFirmware(context.Background(), job)
+		if tc.code == "" {
+			if err != nil || len(got) != len(firmware) {
+				t.Errorf("limit %d: got %d bytes, err %v", tc.limit, len(got), err)
+			}
+		} else if code := errorCode(err); code != tc.code {
+			t.Errorf("limit %d: error code %s (%v), want %s", tc.limit, code, err, tc.code)
+		}
+	}
+}
+
+func TestProcessUsesIDGenerator(t *testing.T) {
+	q := newFakeQueue()
+	n := 0
+	ids := IDGeneratorFunc(func() string {
+		n++
+		return fmt.Sprintf("fixed-%d", n)
+	})
+	// An out-of-range timeout fails the job before the simulator runs.
+	cfg := Config{IDs: ids, MinTimeout: time.Second, MaxTimeout: time.Minute}
+	w := newWorker("w1", q, cfg, simulator.Config{}, noopTelemetry(t))
+
+	for i := 0; i < 2; i++ {
+		w.process(context.Background(), []byte(`{"timeout_seconds":-1}`))
+	}
+	for _, id := range []string{"fixed-1", "fixed-2"} {
+		if res := q.result(t, id); res.JobID != id || res.ErrorCode != CodeInvalidTimeout {
+			t.Errorf("result %s = {JobID:%s ErrorCode:%s}", id, res.JobID, res.ErrorCode)
+		}
+	}
+	if len(q.acked) != 2 {
+		t.Errorf("acked %d jobs, want 2", len(q.acked))
+	}
+}
+
+func TestSimConfigFor(t *testing.T) {
+	w := &Worker{simCfg: simulator.Config{MaxCycles: 10000}}
+	for _, tc := range []struct {
+		simConfig string
+		want      SimConfig
+		code      string
+	}{
+		{"", SimConfig{Board: "stm32f103c8", MaxCycles: 10000}, ""},
+		{`{"board":"BluePill","max_cycles":500}`, SimConfig{Board: "stm32f103c8", MaxCycles: 500}, ""},
+		{`{"board":"stm32f103c8"}`, SimConfig{Board: "stm32f103c8", MaxCycles: 10000}, ""},
+		{`{"board":"stm32f407"}`, SimConfig{}, CodeInvalidSimConfig},
+		{`{"max_cycles":20000}`, SimConfig{}, CodeInvalidSimConfig},
+		{`{"clock_hz":8000000}`, SimConfig{}, CodeInvalidSimConfig},
+		{`{"board":7}`, SimConfig{}, CodeInvalidSimConfig},
+	} {
+		cfg, got, err := w.simConfigFor(Job{SimConfig: json.RawMessage(tc.simConfig)})
+		if tc.code != "" {
+			if code := errorCode(err); code != tc.code {
+				t.Errorf("%s: error code %s (%v), want %s", tc.simConfig, code, err, tc.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.simConfig, err)
+			continue
+		}
+		if got != tc.want || cfg.MaxCycles != tc.want.MaxCycles {
+			t.Errorf("%s: effective %+v (sim max cycles %d), want %+v", tc.simConfig, got, cfg.MaxCycles, tc.want)
+		}
+	}
+}
+
+func TestSimConfigForTimeoutBounds(t *testing.T) {
+	w := &Worker{
+		cfg:    Config{MinTimeout: 5 * time.Second, MaxTimeout: 600 * time.Second},
+		simCfg: simulator.Config{Timeout: 30 * time.Second},
+	}
+	for _, tc := range []struct {
+		seconds int
+		want    time.Duration
+		code    string
+	}{
+		{0, 30 * time.Second, ""}, // worker default
+		{5, 5 * time.Second, ""},
+		{600, 600 * time.Second, ""},
+		{4, 0, CodeInvalidTimeout},
+		{601, 0, CodeInvalidTimeout},
+		{-1, 0, CodeInvalidTimeout},
+		{math.MaxInt, 0, CodeInvalidTimeout}, // overflows a Duration
+	} {
+		cfg, _, err := w.simConfigFor(Job{TimeoutSeconds: tc.seconds})
+		if tc.code != "" {
+			if code := errorCode(err); code != tc.code {
+				t.Errorf("timeout_seconds %d: error code %s (%v), want %s", tc.seconds, code, err, tc.code)
+			} else if !strings.Contains(err.Error(), "between 5 and 600") {
+				t.Errorf("timeout_seconds %d: error %q does not state the range", tc.seconds, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("timeout_seconds %d: %v", tc.seconds, err)
+		} else if cfg.Timeout != tc.want {
+			t.Errorf("timeout_seconds %d: Timeout = %v, want %v", tc.seconds, cfg.Timeout, tc.want)
+		}
+	}
+}
+
+func TestProcessRecordsSimConfig(t *testing.T) {
+	q := newFakeQueue()
+	simCfg := simulator.Config{
+		BinaryPath: "/nonexistent/stm32sim",
+		MaxCycles:  10000,
+		Timeout:    time.Second,
+	}
+	w := newWorker("w1", q, Config{}, simCfg, noopTelemetry(t))
+
+	w.process(context.Background(), []byte(`{"id":"ok","sim_config":{"board":"bluepill","max_cycles":500}}`))
+	w.process(context.Background(), []byte(`{"id":"bad","sim_config":{"board":"nucleo"}}`))
+
+	res := q.result(t, "ok")
+	if res.SimConfig == nil || *res.SimConfig != (SimConfig{Board: "stm32f103c8", MaxCycles: 500}) {
+		t.Errorf("stored sim_config = %+v", res.SimConfig)
+	}
+	if res := q.result(t, "bad"); res.ErrorCode != CodeInvalidSimConfig || res.SimConfig != nil {
+		t.Errorf("invalid sim_config result = {ErrorCode:%s SimConfig:%+v}", res.ErrorCode, res.SimConfig)
+	}
+}
+
+// bootableImage returns a minimal raw image that passes ValidateImage.
+func bootableImage() []byte {
+	img := make([]byte, 256)
+	binary.LittleEndian.PutUint32(img[0:4], 0x20005000)
+	binary.LittleEndian.PutUint32(img[4:8], 0x08000041)
+	return img
+}
+
+func TestProcessAllowList(t *testing.T) {
+	firmware := bootableImage()
+	sum := sha256.Sum256(firmware)
+	sha := hex.EncodeToString(sum[:])
+	raw := []byte(`{"id":"j1","binary_b64":"` + base64.StdEncoding.EncodeToString(firmware) + `"}`)
+
+	for _, tc := range []struct {
+		name    string
+		allowed map[string]bool
+		code    string
+	}{
+		{"feature off", nil, ""},
+		{"allowed", map[string]bool{sha: true}, ""},
+		{"disallowed", map[string]bool{strings.Repeat("0", 64): true}, CodeBinaryNotAllowed},
+	} {
+		q := newFakeQueue()
+		blobs := &fakeBlobs{}
+		cfg := Config{AllowedSHA256: tc.allowed, ValidateImages: true, Blobs: blobs}
+		simCfg := simulator.Config{BinaryPath: fakeSimulator(t, `echo '{"halt_reason":"bkpt"}'`), Timeout: 10 * time.Second}
+		w := newWorker("w1", q, cfg, simCfg, noopTelemetry(t))
+
+		w.process(context.Background(), raw)
+
+		res := q.result(t, "j1")
+		if tc.code == "" {
+			if res.Status != "ok" || blobs.puts != 1 {
+				t.Errorf("%s: status %s (%s), %d blob puts; want ok and 1 put", tc.name, res.Status, res.ErrorMessage, blobs.puts)
+			}
+			continue
+		}
+		if res.ErrorCode != tc.code || !strings.Contains(res.ErrorMessage, sha) {
+			t.Errorf("%s: result {ErrorCode:%s ErrorMessage:%q}, want %s naming the hash", tc.name, res.ErrorCode, res.ErrorMessage, tc.code)
+		}
+		if blobs.puts != 0 {
+			t.Errorf("%s: rejected binary was written to the blob store", tc.name)
+		}
+	}
+}
+
+func TestProcessInvalidImageNotStored(t *testing.T) {
+	q := newFakeQueue()
+	blobs := &fakeBlobs{}
+	w := newWorker("w1", q, Config{ValidateImages: true, Blobs: blobs}, simulator.Config{}, noopTelemetry(t))
+
+	w.process(context.Background(), []byte(`{"id":"j1","binary_b64":"`+base64.StdEncoding.EncodeToString([]byte("not firmware"))+`"}`))
+
+	if res := q.result(t, "j1"); res.ErrorCode != CodeInvalidImage {
+		t.Errorf("ErrorCode = %s, want %s", res.ErrorCode, CodeInvalidImage)
+	}
+	if blobs.puts != 0 {
+		t.Error("invalid image was written to the blob store")
+	}
+}
+
+func TestProcessRecordsEmulatorVersion(t *testing.T) {
+	q := newFakeQueue()
+	simCfg := simulator.Config{
+		BinaryPath: fakeSimulator(t, `echo '{"halt_reason":"bkpt"}'`),
+		Timeout:    10 * time.Second,
+		Version:    "v1.4.2",
+	}
+	w := newWorker("w1", q, Config{}, simCfg, noopTelemetry(t))
+
+	firmware := base64.StdEncoding.EncodeToString(bootableImage())
+	w.process(context.Background(), []byte(`{"id":"ran","binary_b64":"`+firmware+`"}`))
+	w.process(context.Background(), []byte(`{"id":"failed","binary_b64":"!!"}`))
+
+	for _, id := range []string{"ran", "failed"} {
+		if res := q.result(t, id); res.EmulatorVersion != "v1.4.2" {
+			t.Errorf("%s: EmulatorVersion = %q, want v1.4.2", id, res.EmulatorVersion)
+		}
+	}
+	if res := q.result(t, "failed"); res.ErrorCode != CodeInvalidBase64 {
+		t.Errorf("failed: ErrorCode = %s, want %s", res.ErrorCode, CodeInvalidBase64)
+	}
+	if len(q.versions) == 0 {
+		t.Fatal("no worker status published")
+	}
+	for _, v := range q.versions {
+		if v != "v1.4.2" {
+			t.Errorf("worker status published version %q", v)
+		}
+	}
+}
+
+func TestNormalizeMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{`{"student":"a1","lab":3}`, `{"student":"a1","lab":3}`},
+		{` {"k":[1,2]} `, ` {"k":[1,2]} `},
+		{`{}`, `{}`},
+		{``, ``},
+		{`   `, ``},
+		{`null`, ``},
+		{` null `, ``},
+		{`[1,2]`, ``},
+		{`"note"`, ``},
+		{`42`, ``},
+		{`true`, ``},
+	} {
+		if got := normalizeMetadata("j", json.RawMessage(tc.in)); string(got) != tc.want {
+			t.Errorf("normalizeMetadata(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestProcessDropsNonObjectMetadata(t *testing.T) {
+	q := newFakeQueue()
+	simCfg := simulator.Config{BinaryPath: fakeSimulator(t, `echo '{"halt_reason":"bkpt"}'`), Timeout: 10 * time.Second}
+	w := newWorker("w1", q, Config{}, simCfg, noopTelemetry(t))
+
+	firmware := base64.StdEncoding.EncodeToString(bootableImage())
+	w.process(context.Background(), []byte(`{"id":"j1","binary_b64":"`+firmware+`","metadata":["not","an","object"]}`))
+
+	res := q.result(t, "j1")
+	if res.Status != "ok" {
+		t.Errorf("status = %s (%s %s), want ok", res.Status, res.ErrorCode, res.ErrorMessage)
+	}
+	if res.Metadata != nil {
+		t.Errorf("stored metadata = %s, want none", res.Metadata)
+	}
+}
+
+// flakyQueue is a fakeQueue whose Dequeue follows a script: each step either
+// fails with an error or, when nil, hands out a job. Past the script it
+// blocks until ctx is cancelled.
+type flakyQueue struct {
+	*fakeQueue
+	mu    sync.Mutex
+	steps []error
+	// waits[i] is the time between the i-th failure and the next call.
+	waits  []time.Duration
+	failed time.Time
+	// onFail, when set, runs before each failure is returned.
+	onFail func()
+}
+
+func (f *flakyQueue) Dequeue(ctx context.Context, workerID string) ([]byte, error) {
+	f.mu.Lock()
+	if !f.failed.IsZero() {
+		f.waits = append(f.waits, time.Since(f.failed))
+		f.failed = time.Time{}
+	}
+	if len(f.steps) == 0 {
+		f.mu.Unlock()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	step := f.steps[0]
+	f.steps = f.steps[1:]
+	if step == nil {
+		f.mu.Unlock()
+		return []byte(`{"id":"j1","timeout_seconds":-1}`), nil
+	}
+	f.failed = time.Now()
+	onFail := f.onFail
+	f.mu.Unlock()
+	if onFail != nil {
+		onFail()
+	}
+	return nil, step
+}
+
+func shortBackoff(t *testing.T, lo, hi time.Duration) {
+	t.Helper()
+	oldMin, oldMax := dequeueBackoffMin, dequeueBackoffMax
+	dequeueBackoffMin, dequeueBackoffMax = lo, hi
+	t.Cleanup(func() { dequeueBackoffMin, dequeueBackoffMax = oldMin, oldMax })
+}
+
+func TestRunBacksOffOnDequeueErrors(t *testing.T) {
+	shortBackoff(t, 20*time.Millisecond, 80*time.Millisecond)
+	down := errors.New("connection refused")
+	q := &flakyQueue{
+		fakeQueue: newFakeQueue(),
+		// Five failures, a job, then one more failure.
+		steps: []error{down, down, down, down, down, nil, down},
+	}
+	w := newWorker("w1", q, Config{}, simulator.Config{}, noopTelemetry(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx, context.Background())
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.mu.Lock()
+		n := len(q.waits)
+		q.mu.Unlock()
+		if n == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d dequeue retries after 5s", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// The delay doubles up to the cap, then starts over after a success.
+	want := []time.Duration{20, 40, 80, 80, 80, 20}
+	for i, wait := range q.waits {
+		lo := want[i] * time.Millisecond
+		if wait < lo {
+			t.Errorf("retry %d after %v, want at least %v", i+1, wait, lo)
+		}
+	}
+	if last := q.waits[5]; last >= 80*time.Millisecond {
+		t.Errorf("retry after a successful dequeue waited %v; backoff was not reset", last)
+	}
+	if _, ok := q.results["j1"]; !ok {
+		t.Error("job handed out between failures was not processed")
+	}
+}
+
+func TestRunStopsWhileBackingOff(t *testing.T) {
+	shortBackoff(t, time.Hour, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := &flakyQueue{
+		fakeQueue: newFakeQueue(),
+		steps:     []error{errors.New("connection refused")},
+		// Cancel once Run is waiting out the backoff.
+		onFail: func() { time.AfterFunc(50*time.Millisecond, cancel) },
+	}
+	w := newWorker("w1", q, Config{}, simulator.Config{}, noopTelemetry(t))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx, context.Background())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel while backing off")
+	}
+}
@@ -0,0 +1,157 @@
+// Package client submits firmware to the simulation service through its
+// KeyDB job queue and reads back the results.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"stm32sim-service/internal/queue"
+	"stm32sim-service/internal/worker"
+)
+
+// Result is the worker's result format; Options configures the KeyDB
+// connection, including ACL credentials and TLS.
+type (
+	Result  = worker.Result
+	Options = queue.Options
+)
+
+// Job is a simulation request. It carries the fields of the worker's job
+// format that a submitter sets; bookkeeping such as the reaper's attempt
+// counter is left to the service.
+type Job struct {
+	ID string `json:"id"`
+	// BinaryB64 carries the firmware inline; BinarySHA256 references a
+	// binary already in the service's blob store instead.
+	BinaryB64    string          `json:"binary_b64,omitempty"`
+	BinarySHA256 string          `json:"binary_sha256,omitempty"`
+	SubmittedAt  string          `json:"submitted_at,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	Tags         []string        `json:"tags,omitempty"`
+	// TimeoutSeconds overrides the service's simulator timeout, within the
+	// bounds it allows.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// SkipImageCheck disables the service's firmware image validation.
+	SkipImageCheck bool `json:"skip_image_check,omitempty"`
+	// SimConfig is a worker.SimConfig object.
+	SimConfig json.RawMessage `json:"sim_config,omitempty"`
+}
+
+var (
+	// ErrNoResult is returned by Result while a job has not finished yet.
+	ErrNoResult = queue.ErrNoResult
+	// ErrQueueFull is returned by Submit when the service's pending-job cap
+	// (SIM_MAX_PENDING) is reached; the job was not queued and may be
+	// retried later.
+	ErrQueueFull = queue.ErrQueueFull
+)
+
+const defaultPollInterval = 500 * time.Millisecond
+
+type Client struct {
+	q *queue.Queue
+	// PollInterval is how often Wait checks for a result; values that are
+	// not positive use 500ms.
+	PollInterval time.Duration
+}
+
+// New connects to the KeyDB instance the service's workers consume from.
+// A zero opts.OpTimeout defaults to 5s.
+func New(opts Options) (*Client, error) {
+	if opts.OpTimeout == 0 {
+		opts.OpTimeout = 5 * time.Second
+	}
+	q, err := queue.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{q: q, PollInterval: defaultPollInterval}, nil
+}
+
+func (c *Client) Close() error {
+	return c.q.Close()
+}
+
+// NewJob returns a job carrying binary inline.
+func NewJob(binary []byte) Job {
+	return Job{BinaryB64: base64.StdEncoding.EncodeToString(binary)}
+}
+
+// Submit enqueues job and returns its ID, generating one if job.ID is empty.
+func (c *Client) Submit(ctx context.Context, job Job) (string, error) {
+	if job.BinaryB64 == "" && job.BinarySHA256 == "" {
+		return "", errors.New("job has neither binary_b64 nor binary_sha256")
+	}
+	if job.ID == "" {
+		id, err := newJobID()
+		if err != nil {
+			return "", err
+		}
+		job.ID = id
+	}
+	if job.SubmittedAt == "" {
+		job.SubmittedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return "", err
+	}
+	if err := c.q.Enqueue(ctx, raw); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// Result returns the job's result, or ErrNoResult if it has not finished.
+func (c *Client) Result(ctx context.Context, jobID string) (*Result, error) {
+	data, err := c.q.Result(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	var res Result
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// JobsByTag returns the IDs of finished jobs carrying tag, newest first,
+// at most limit of them (all when limit is not positive).
+func (c *Client) JobsByTag(ctx context.Context, tag string, limit int) ([]string, error) {
+	return c.q.JobsByTag(ctx, tag, limit)
+}
+
+// Wait polls until the job has a result or ctx is done.
+func (c *Client) Wait(ctx context.Context, jobID string) (*Result, error) {
+	interval := c.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res, err := c.Result(ctx, jobID)
+		if !errors.Is(err, ErrNoResult) {
+			return res, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func newJobID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return "job-" + hex.EncodeToString(b[:]), nil
+}
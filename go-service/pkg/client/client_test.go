@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"stm32sim-service/internal/queue"
+	"stm32sim-service/internal/worker"
+)
+
+// newTestClient connects to the KeyDB at KEYDB_TEST_ADDR and empties database
+// KEYDB_TEST_DB (default 15) before and after the test, like the queue
+// package's tests. It is skipped when KEYDB_TEST_ADDR is unset.
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	addr := os.Getenv("KEYDB_TEST_ADDR")
+	if addr == "" {
+		t.Skip("KEYDB_TEST_ADDR not set")
+	}
+	db := 15
+	if v := os.Getenv("KEYDB_TEST_DB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			t.Fatalf("KEYDB_TEST_DB: %v", err)
+		}
+		db = n
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr, DB: db})
+	ctx := context.Background()
+	if err := rdb.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("flush test db: %v", err)
+	}
+	c, err := New(Options{Addr: addr, DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PollInterval = 20 * time.Millisecond
+	t.Cleanup(func() {
+		c.Close()
+		rdb.FlushDB(ctx)
+		rdb.Close()
+	})
+	return c
+}
+
+// TestJobMatchesWorkerFormat checks that every field a client can set is
+// understood by the worker under the same name.
+func TestJobMatchesWorkerFormat(t *testing.T) {
+	job := Job{
+		ID:             "job-1",
+		BinaryB64:      "AAAA",
+		BinarySHA256:   "ab",
+		SubmittedAt:    "2026-01-01T00:00:00Z",
+		Metadata:       json.RawMessage(`{"student":"s1"}`),
+		Tags:           []string{"lab1"},
+		TimeoutSeconds: 10,
+		SkipImageCheck: true,
+		SimConfig:      json.RawMessage(`{"board":"bluepill"}`),
+	}
+	raw, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got worker.Job
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("worker cannot decode client job %s: %v", raw, err)
+	}
+	want := worker.Job{
+		ID:             job.ID,
+		BinaryB64:      job.BinaryB64,
+		BinarySHA256:   job.BinarySHA256,
+		SubmittedAt:    job.SubmittedAt,
+		Metadata:       job.Metadata,
+		Tags:           job.Tags,
+		TimeoutSeconds: job.TimeoutSeconds,
+		SkipImageCheck: job.SkipImageCheck,
+		SimConfig:      job.SimConfig,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("worker decoded %+v, want %+v", got, want)
+	}
+	if _, ok := reflect.TypeOf(Job{}).FieldByName("Attempts"); ok {
+		t.Error("client Job exposes the reaper's Attempts field")
+	}
+}
+
+func TestSubmitRequiresBinary(t *testing.T) {
+	c := &Client{}
+	if _, err := c.Submit(context.Background(), Job{ID: "j1"}); err == nil {
+		t.Error("Submit accepted a job without a binary")
+	}
+}
+
+// TestWaitZeroPollInterval uses a zero-value PollInterval against an
+// unreachable KeyDB: Wait must return the connection error, not panic.
+func TestWaitZeroPollInterval(t *testing.T) {
+	q, err := queue.New(queue.Options{Addr: "127.0.0.1:1", OpTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	c := &Client{q: q}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.Wait(ctx, "job-1"); err == nil {
+		t.Error("Wait returned no error for an unreachable KeyDB")
+	}
+}
+
+func TestSubmitWaitRoundTrip(t *testing.T) {
+	c := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job := NewJob([]byte{1, 2, 3})
+	job.Tags = []string{"lab1"}
+	id, err := c.Submit(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand in for a worker: take the job and store its result while Wait
+	// is polling.
+	raw, err := c.q.Dequeue(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got worker.Job
+	if err := json.Unmarshal(raw, &got); err != nil || got.ID != id || got.BinaryB64 != job.BinaryB64 || got.SubmittedAt == "" {
+		t.Fatalf("queued job = %s (%v)", raw, err)
+	}
+	if _, err := c.Result(ctx, id); !errors.Is(err, ErrNoResult) {
+		t.Errorf("Result before completion: err = %v, want ErrNoResult", err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() {
+		c.q.StoreResult(context.Background(), id, worker.Result{JobID: id, Status: "ok"}, got.Tags...)
+		c.q.AckDone(context.Background(), raw)
+	})
+
+	res, err := c.Wait(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.JobID != id || res.Status != "ok" {
+		t.Errorf("Wait = %+v", res)
+	}
+	if ids, err := c.JobsByTag(ctx, "lab1", 0); err != nil || len(ids) != 1 || ids[0] != id {
+		t.Errorf("JobsByTag(lab1) = %v, %v", ids, err)
+	}
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	if err := c.q.SetMaxPending(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Submit(ctx, NewJob([]byte{1})); err != nil {
+		t.Fatalf("first submit: %v", err)
+	}
+	if _, err := c.Submit(ctx, NewJob([]byte{2})); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("submit past the cap: err = %v, want ErrQueueFull", err)
+	}
+}
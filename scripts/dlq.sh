@@ -0,0 +1,109 @@
+#!/usr/bin/env bash
+set -e
+
+KEYDB_PORT="${KEYDB_PORT:-6379}"
+CMD="${1:-list}"
+
+usage() {
+    echo "Usage: $0 list"
+    echo "       $0 replay <job_id>"
+    exit 1
+}
+
+if ! command -v redis-cli &>/dev/null; then
+    echo "ERROR: redis-cli not found (install redis-tools)"
+    exit 1
+fi
+
+if ! redis-cli -p "$KEYDB_PORT" PING &>/dev/null; then
+    echo "ERROR: cannot reach KeyDB on port $KEYDB_PORT"
+    echo "Run 'make up' first"
+    exit 1
+fi
+
+case "$CMD" in
+list)
+    # One dead job per line: id, attempts and the error stored in its result.
+    redis-cli -p "$KEYDB_PORT" --raw LRANGE sim:jobs:dead 0 -1 |
+    while IFS= read -r RAW; do
+        [ -z "$RAW" ] && continue
+        # The reaper dead-letters unparseable payloads as-is; show them raw.
+        JOB_ID=$(printf '%s' "$RAW" | python3 -c '
+import json, sys
+try:
+    print(json.load(sys.stdin).get("id", ""))
+except (ValueError, AttributeError):
+    print("")')
+        if [ -z "$JOB_ID" ]; then
+            printf 'unparseable\t%.80s\n' "$RAW"
+            continue
+        fi
+        DETAIL=$(redis-cli -p "$KEYDB_PORT" --raw GET "sim:jobs:detail:$JOB_ID")
+        printf '%s' "$RAW" | DETAIL="$DETAIL" python3 -c '
+import json, os, sys
+job = json.load(sys.stdin)
+try:
+    err = json.loads(os.environ["DETAIL"]).get("error_message", "")
+except ValueError:
+    err = ""
+print("%s\tattempts=%s\t%s" % (job.get("id", "?"), job.get("attempts", 0), err))'
+    done
+    ;;
+replay)
+    JOB_ID="$2"
+    [ -z "$JOB_ID" ] && usage
+    RAW=$(redis-cli -p "$KEYDB_PORT" --raw LRANGE sim:jobs:dead 0 -1 |
+        JOB_ID="$JOB_ID" python3 -c '
+import json, os, sys
+for line in sys.stdin:
+    line = line.rstrip("\n")
+    try:
+        job = json.loads(line)
+    except ValueError:
+        continue
+    if isinstance(job, dict) and job.get("id") == os.environ["JOB_ID"]:
+        print(line)
+        break')
+    if [ -z "$RAW" ]; then
+        echo "ERROR: job $JOB_ID is not in the dead-letter queue"
+        exit 1
+    fi
+    # Reset the attempt counter so the job gets a full set of retries again.
+    FRESH=$(printf '%s' "$RAW" | python3 -c '
+import json, sys
+job = json.load(sys.stdin)
+job.pop("attempts", None)
+print(json.dumps(job, separators=(",", ":")))')
+    # The capped push of enqueueScript in go-service/internal/queue/keydb.go,
+    # so SIM_MAX_PENDING applies to replays too. Taking the job off the
+    # dead-letter list and dropping its JOB_ABANDONED result (so pollers wait
+    # for the rerun) happen in the same script: a full queue leaves the job
+    # where it was.
+    REPLAY_LUA="local limit = tonumber(redis.call('GET', KEYS[3]) or '0') or 0
+if limit > 0 and redis.call('LLEN', KEYS[1]) >= limit then
+    redis.call('HINCRBY', KEYS[2], 'jobs_rejected', 1)
+    return -1
+end
+if redis.call('LREM', KEYS[4], 1, ARGV[1]) == 0 then
+    return 0
+end
+redis.call('DEL', KEYS[5], KEYS[6])
+return redis.call('LPUSH', KEYS[1], ARGV[2])"
+    PUSHED=$(redis-cli -p "$KEYDB_PORT" EVAL "$REPLAY_LUA" 6 \
+        sim:jobs:pending sim:stats sim:config:max_pending sim:jobs:dead \
+        "sim:results:$JOB_ID" "sim:jobs:detail:$JOB_ID" \
+        "$RAW" "$FRESH")
+    if [ "$PUSHED" = "-1" ]; then
+        echo "ERROR: job queue is full (SIM_MAX_PENDING reached); $JOB_ID stays in the dead-letter queue"
+        exit 1
+    fi
+    if [ "$PUSHED" = "0" ]; then
+        echo "ERROR: job $JOB_ID was removed from the dead-letter queue concurrently"
+        exit 1
+    fi
+    echo "Requeued $JOB_ID"
+    ;;
+*)
+    usage
+    ;;
+esac
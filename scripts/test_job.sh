@@ -27,9 +27,21 @@ SUBMITTED_AT=$(date -u +%FT%TZ)
 
 echo "Submitting job $JOB_ID ($(wc -c < "$BINARY") bytes)..."
 
-redis-cli -p "$KEYDB_PORT" LPUSH sim:jobs:pending \
-    "{\"id\":\"$JOB_ID\",\"binary_b64\":\"$BIN_B64\",\"submitted_at\":\"$SUBMITTED_AT\"}" \
-    > /dev/null
+# Same capped push as enqueueScript in go-service/internal/queue/keydb.go,
+# so the service's SIM_MAX_PENDING applies here too.
+ENQUEUE_LUA="local limit = tonumber(redis.call('GET', KEYS[3]) or '0') or 0
+if limit > 0 and redis.call('LLEN', KEYS[1]) >= limit then
+    redis.call('HINCRBY', KEYS[2], 'jobs_rejected', 1)
+    return -1
+end
+return redis.call('LPUSH', KEYS[1], ARGV[1])"
+PUSHED=$(redis-cli -p "$KEYDB_PORT" EVAL "$ENQUEUE_LUA" 3 \
+    sim:jobs:pending sim:stats sim:config:max_pending \
+    "{\"id\":\"$JOB_ID\",\"binary_b64\":\"$BIN_B64\",\"submitted_at\":\"$SUBMITTED_AT\"}")
+if [ "$PUSHED" = "-1" ]; then
+    echo "ERROR: job queue is full (SIM_MAX_PENDING reached); try again later"
+    exit 1
+fi
 
 echo "Waiting for result (up to 60 s)..."
 for i in $(seq 1 60); do